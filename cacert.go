@@ -0,0 +1,179 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	caCertFilename = "vmwareworkstation-ca"
+
+	// Bundles of the guest trust stores, boot2docker and Debian use the
+	// first one, Red Hat the second one.
+	caBundlePaths = "/etc/ssl/certs/ca-certificates.crt /etc/pki/tls/certs/ca-bundle.crt"
+)
+
+var ErrCACertNotTrusted = errors.New("guest does not trust the CA certificate")
+
+// caCert is a CA certificate to install in the guest.
+type caCert struct {
+	Path    string
+	Subject string
+	PEM     []byte
+}
+
+// marker returns a line of the PEM encoding of the certificate, the trust
+// stores of the guests keep it when they rebuild their bundle.
+func (c caCert) marker() string {
+	lines := strings.Split(strings.TrimSpace(string(c.PEM)), "\n")
+	// The middle line is never the BEGIN or the END line
+	return lines[len(lines)/2]
+}
+
+// readCACerts returns the certificates of the PEM files, or of every *.pem
+// and *.crt file of the directories.
+func readCACerts(paths []string) ([]caCert, error) {
+	var certs []caCert
+	seen := map[string]bool{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA certificates: %s", err)
+		}
+
+		files := []string{path}
+		if info.IsDir() {
+			pems, _ := filepath.Glob(filepath.Join(path, "*.pem"))
+			crts, _ := filepath.Glob(filepath.Join(path, "*.crt"))
+			files = append(pems, crts...)
+			sort.Strings(files)
+		}
+
+		for _, file := range files {
+			fileCerts, err := readCACertFile(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, cert := range fileCerts {
+				if !seen[string(cert.PEM)] {
+					seen[string(cert.PEM)] = true
+					certs = append(certs, cert)
+				}
+			}
+		}
+	}
+	return certs, nil
+}
+
+func readCACertFile(file string) ([]caCert, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []caCert
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CA certificate in %s: %s", file, err)
+		}
+		if !cert.IsCA {
+			log.Warnf("Certificate %s in %s is not a CA certificate", cert.Subject.CommonName, file)
+		}
+		certs = append(certs, caCert{
+			Path:    file,
+			Subject: cert.Subject.CommonName,
+			PEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes}),
+		})
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No PEM certificate found in %s", file)
+	}
+	return certs, nil
+}
+
+// installCACerts adds the CA certificates to the trust store of the guest
+// unless it trusts them already, then checks the guest trusts them.
+func (d *Driver) installCACerts() error {
+	certs, err := readCACerts(d.CACerts)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 || d.untrustedCACert(certs) == nil {
+		return nil
+	}
+
+	log.Infof("Installing %d CA certificates in the guest...", len(certs))
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		bundle.Write(cert.PEM)
+	}
+	if err := executeSSHCommandInput(caCertsCommand(), &bundle, d); err != nil {
+		return fmt.Errorf("Unable to install the CA certificates: %s", err)
+	}
+
+	if cert := d.untrustedCACert(certs); cert != nil {
+		return fmt.Errorf("%s: %s from %s", ErrCACertNotTrusted, cert.Subject, cert.Path)
+	}
+	return nil
+}
+
+// untrustedCACert returns the first certificate missing from the trust store
+// of the guest.
+func (d *Driver) untrustedCACert(certs []caCert) *caCert {
+	for i, cert := range certs {
+		command := fmt.Sprintf("cat %s 2>/dev/null | grep -qF %s", caBundlePaths, shQuote(cert.marker()))
+		if err := executeSSHCommand(command, d); err != nil {
+			return &certs[i]
+		}
+	}
+	return nil
+}
+
+// caCertsCommand returns the guest command installing the certificates read
+// from stdin. boot2docker adds the certificates of its persistent certs
+// directory to the bundle on boot, so they are added to the bundle by hand
+// the first time.
+func caCertsCommand() string {
+	tmp := "/tmp/" + caCertFilename + ".pem"
+	return strings.Join([]string{
+		"cat > " + tmp,
+		"if [ -d /var/lib/boot2docker ]; then " +
+			"sudo mkdir -p /var/lib/boot2docker/certs && " +
+			"sudo cp " + tmp + " /var/lib/boot2docker/certs/" + caCertFilename + ".pem && " +
+			"sudo sh -c 'cat " + tmp + " >> /etc/ssl/certs/ca-certificates.crt'; " +
+			"elif command -v update-ca-certificates > /dev/null; then " +
+			"sudo cp " + tmp + " /usr/local/share/ca-certificates/" + caCertFilename + ".crt && sudo update-ca-certificates; " +
+			"elif command -v update-ca-trust > /dev/null; then " +
+			"sudo cp " + tmp + " /etc/pki/ca-trust/source/anchors/" + caCertFilename + ".pem && sudo update-ca-trust extract; " +
+			"else echo 'no supported trust store in the guest' >&2; exit 1; fi",
+		"rm -f " + tmp,
+		// Docker only reads the trust store when it starts
+		"if [ -x /etc/init.d/docker ]; then sudo /etc/init.d/docker restart; " +
+			"elif command -v systemctl > /dev/null; then sudo systemctl try-restart docker; fi",
+	}, " && ")
+}
@@ -0,0 +1,122 @@
+package vmwareworkstation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCACertPEM(t *testing.T, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestReadCACerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	root, proxy := testCACertPEM(t, "Root CA"), testCACertPEM(t, "Proxy CA")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "corp.pem"), append(append([]byte{}, root...), proxy...), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "proxy.crt"), proxy, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a cert"), 0644))
+
+	certs, err := readCACerts([]string{dir})
+
+	assert.NoError(t, err)
+	if assert.Len(t, certs, 2) {
+		assert.Equal(t, "Root CA", certs[0].Subject)
+		assert.Equal(t, root, certs[0].PEM)
+		assert.Equal(t, "Proxy CA", certs[1].Subject)
+		assert.Contains(t, string(root), certs[0].marker())
+		assert.False(t, strings.HasPrefix(certs[0].marker(), "-----"))
+	}
+}
+
+func TestReadCACertsInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("not a cert")
+	file.Close()
+
+	_, err = readCACerts([]string{file.Name()})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No PEM certificate found")
+}
+
+func TestInstallCACerts(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+
+	cert := testCACertPEM(t, "Proxy CA")
+	certFile := filepath.Join(driver.ResolveStorePath("."), "proxy.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, cert, 0644))
+	driver.CACerts = []string{certFile}
+
+	installed := false
+	server.handler = func(command string) (string, uint32) {
+		if strings.HasPrefix(command, "cat > ") {
+			installed = true
+		}
+		if strings.Contains(command, "grep -qF") && !installed {
+			return "", 1
+		}
+		return "", 0
+	}
+
+	err := driver.installCACerts()
+
+	assert.NoError(t, err)
+	assert.Contains(t, server.Stdins(), string(cert))
+	assert.Len(t, server.Commands(), 3)
+}
+
+func TestInstallCACertsNotTrusted(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+
+	certFile := filepath.Join(driver.ResolveStorePath("."), "proxy.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, testCACertPEM(t, "Proxy CA"), 0644))
+	driver.CACerts = []string{certFile}
+	server.handler = func(command string) (string, uint32) {
+		if strings.Contains(command, "grep -qF") {
+			return "", 1
+		}
+		return "", 0
+	}
+
+	err := driver.installCACerts()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrCACertNotTrusted.Error())
+	assert.Contains(t, err.Error(), "Proxy CA")
+}
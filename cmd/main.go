@@ -1,10 +1,188 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/docker/machine/libmachine/drivers/plugin"
+	"github.com/docker/machine/libmachine/state"
 	"github.com/pecigonzalo/docker-machine-vmwareworkstation"
 )
 
+// commands are helpers run directly by the user, docker-machine always
+// starts the plugin without arguments.
+var commands = map[string]func(args []string) error{
+	"watch":       watch,
+	"sync":        sync,
+	"sync-status": syncStatus,
+	"vnc":         vnc,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command %q, available commands: %s\n", os.Args[1], strings.Join(commandNames(), ", "))
+			os.Exit(2)
+		}
+		if err := command(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	plugin.RegisterDriver(vmwareworkstation.NewDriver("", ""))
 }
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadMachine parses the common flags and loads the machine named by the
+// only argument left.
+func loadMachine(flags *flag.FlagSet, args []string) (*vmwareworkstation.Driver, error) {
+	storePath := flags.String("storage-path", "", "Docker Machine storage path (defaults to $MACHINE_STORAGE_PATH or ~/.docker/machine)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return nil, fmt.Errorf("usage: %s [options] <machine>", flags.Name())
+	}
+	return vmwareworkstation.LoadDriver(*storePath, flags.Arg(0))
+}
+
+// watch forwards the changes made on the host in the shared folders of a
+// running machine until interrupted.
+func watch(args []string) error {
+	defaults := vmwareworkstation.NewWatcher(nil)
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", defaults.Interval, "Interval between two scans of the shared folders that cannot be watched")
+	debounce := flags.Duration("debounce", defaults.Debounce, "Quiet time before forwarding changes to the guest")
+	ignore := flags.String("ignore", strings.Join(defaults.Ignore, ","), "Comma separated file name patterns to skip")
+
+	d, err := loadMachine(flags, args)
+	if err != nil {
+		return err
+	}
+	if d.IPAddress, err = d.GetIP(); err != nil {
+		return err
+	}
+
+	w := vmwareworkstation.NewWatcher(d)
+	w.Interval, w.Debounce = *interval, *debounce
+	w.Ignore = nil
+	if *ignore != "" {
+		w.Ignore = strings.Split(*ignore, ",")
+	}
+
+	return w.Run(stopOnInterrupt())
+}
+
+// stopOnInterrupt returns a channel closed on Ctrl+C.
+func stopOnInterrupt() <-chan struct{} {
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+	return stop
+}
+
+// sync keeps the guest copies of the shares of a machine using the rsync
+// share driver up to date until interrupted.
+func sync(args []string) error {
+	defaults := vmwareworkstation.NewWatcher(nil)
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	interval := flags.Duration("interval", defaults.Interval, "Interval between two scans of the shared folders that cannot be watched")
+	debounce := flags.Duration("debounce", defaults.Debounce, "Quiet time before syncing changes to the guest")
+
+	d, err := loadMachine(flags, args)
+	if err != nil {
+		return err
+	}
+	if d.IPAddress, err = d.GetIP(); err != nil {
+		return err
+	}
+
+	s := vmwareworkstation.NewSyncer(d)
+	s.Watcher.Interval, s.Watcher.Debounce = *interval, *debounce
+
+	return s.Run(stopOnInterrupt())
+}
+
+// syncStatus prints the state the sync command saved for a machine.
+func syncStatus(args []string) error {
+	d, err := loadMachine(flag.NewFlagSet("sync-status", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	status, err := vmwareworkstation.ReadSyncStatus(d)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s has never been synced", d.MachineName)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("State:   %s\n", status.State)
+	fmt.Printf("Updated: %s\n", status.Updated.Format(time.RFC3339))
+	if status.LastError != "" {
+		fmt.Printf("Error:   %s\n", status.LastError)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARE\tHOST\tGUEST\tLAST SYNC\tCHANGES")
+	for _, share := range status.Shares {
+		lastSync, changes := "never", ""
+		if !share.LastSync.IsZero() {
+			lastSync = share.LastSync.Format(time.RFC3339)
+			changes = "full"
+			if share.Changes >= 0 {
+				changes = fmt.Sprintf("%d", share.Changes)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", share.Name, share.ShareFolder, share.GuestFolder, lastSync, changes)
+	}
+	return w.Flush()
+}
+
+// vnc prints the VNC endpoint of a machine.
+func vnc(args []string) error {
+	flags := flag.NewFlagSet("vnc", flag.ExitOnError)
+	showPassword := flags.Bool("password", false, "Also print the VNC password to stdout")
+
+	d, err := loadMachine(flags, args)
+	if err != nil {
+		return err
+	}
+	endpoint, err := d.VNCEndpoint()
+	if err != nil {
+		return err
+	}
+
+	if s, _ := d.GetState(); s != state.Running {
+		fmt.Fprintf(os.Stderr, "%s is not running, the VNC console is only available once it is started\n", d.MachineName)
+	}
+	if d.VNCPassword == "" {
+		fmt.Fprintf(os.Stderr, "The VNC console of %s has no password, anyone reaching the host can take it over\n", d.MachineName)
+	}
+	fmt.Println(endpoint)
+	if *showPassword && d.VNCPassword != "" {
+		fmt.Printf("Password: %s\n", d.VNCPassword)
+	}
+	return nil
+}
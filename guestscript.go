@@ -0,0 +1,112 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const guestScriptTimeout = 30 * time.Minute
+
+// GuestScript is a host script run in the guest once the VM is reachable,
+// once after create or after every start.
+type GuestScript struct {
+	Path   string
+	Always bool
+}
+
+// parseGuestScript parses a guest script of the form path[:once|:always].
+func parseGuestScript(value string) (GuestScript, error) {
+	script := GuestScript{Path: value}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		switch value[i+1:] {
+		case "once":
+			script.Path = value[:i]
+		case "always":
+			script.Path, script.Always = value[:i], true
+		}
+	}
+
+	if info, err := os.Stat(script.Path); err != nil || info.IsDir() {
+		return GuestScript{}, fmt.Errorf("invalid guest script %q, it must be a file", script.Path)
+	}
+	return script, nil
+}
+
+func parseGuestScripts(values []string) ([]GuestScript, error) {
+	var scripts []GuestScript
+	for _, value := range values {
+		script, err := parseGuestScript(value)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// guestScriptLogPath returns the file of the machine dir the output of the
+// script goes to.
+func (d *Driver) guestScriptLogPath(script GuestScript) string {
+	name := strings.TrimSuffix(filepath.Base(script.Path), filepath.Ext(script.Path))
+	return d.ResolveStorePath(fmt.Sprintf("guest-script-%s.log", name))
+}
+
+// runGuestScripts runs the guest scripts in order, the ones run once only
+// when the machine was just created.
+func (d *Driver) runGuestScripts(created bool) error {
+	if len(d.GuestScripts) == 0 {
+		return nil
+	}
+	if err := d.waitForIP(); err != nil {
+		return err
+	}
+
+	for _, script := range d.GuestScripts {
+		if !script.Always && !created {
+			continue
+		}
+		if err := d.runGuestScript(script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGuestScript copies the script to the guest over SSH and runs it, its
+// output is appended to its log in the machine dir.
+func (d *Driver) runGuestScript(script GuestScript) error {
+	f, err := os.Open(script.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	logPath := d.guestScriptLogPath(script)
+	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	fmt.Fprintf(logFile, "=== %s %s\n", time.Now().Format(time.RFC3339), script.Path)
+
+	log.Infof("Running guest script %s...", script.Path)
+	guestPath := "/tmp/vmwareworkstation-" + filepath.Base(script.Path)
+	// Windows editors may save the script with CRLF line endings
+	command := fmt.Sprintf(
+		"tr -d '\\r' > %s && chmod 755 %s && %s; status=$?; rm -f %s; exit $status",
+		shQuote(guestPath), shQuote(guestPath), shQuote(guestPath), shQuote(guestPath),
+	)
+	if err := runSSHCommand(d, command, f, logFile, logFile, guestScriptTimeout); err != nil {
+		return fmt.Errorf("Guest script %s failed: %s, see %s", script.Path, err, logPath)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package vmwareworkstation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGuestScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "setup.sh")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("true\n"), 0644))
+
+	scripts, err := parseGuestScripts([]string{path, path + ":once", path + ":always"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []GuestScript{{Path: path}, {Path: path}, {Path: path, Always: true}}, scripts)
+
+	_, err = parseGuestScript(path + ".missing:always")
+	assert.Error(t, err)
+}
+
+func TestRunGuestScript(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+	path := filepath.Join(driver.ResolveStorePath("."), "setup.sh")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\r\necho installed\r\n"), 0644))
+	server.handler = func(command string) (string, uint32) {
+		return "installed\n", 0
+	}
+
+	err := driver.runGuestScript(GuestScript{Path: path})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"#!/bin/sh\r\necho installed\r\n"}, server.Stdins())
+	assert.True(t, strings.HasPrefix(server.Commands()[0], "tr -d '\\r' > '/tmp/vmwareworkstation-setup.sh'"))
+	output, err := ioutil.ReadFile(driver.guestScriptLogPath(GuestScript{Path: path}))
+	assert.NoError(t, err)
+	assert.Contains(t, string(output), path+"\ninstalled\n")
+}
+
+func TestRunGuestScriptFailure(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+	path := filepath.Join(driver.ResolveStorePath("."), "setup.sh")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("exit 2\n"), 0644))
+	server.handler = func(command string) (string, uint32) {
+		return "", 2
+	}
+
+	err := driver.runGuestScript(GuestScript{Path: path})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Guest script "+path+" failed")
+	assert.Contains(t, err.Error(), "guest-script-setup.log")
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// defaultHardwareVersion is used when the Workstation version cannot be
+	// detected, every supported Workstation runs it.
+	defaultHardwareVersion = 10
+	defaultGuestOS         = "other3xlinux-64"
+
+	// nestedHardwareVersion is the first hardware version supporting nested
+	// virtualization and virtual performance counters.
+	nestedHardwareVersion = 9
+
+	firmwareBIOS          = "bios"
+	firmwareEFI           = "efi"
+	firmwareEFISecureBoot = "efi-secureboot"
+
+	// secureBootHardwareVersion is the first hardware version supporting
+	// UEFI secure boot.
+	secureBootHardwareVersion = 14
+)
+
+var guestOSRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// hardwareLimit is what a virtual hardware version supports.
+type hardwareLimit struct {
+	CPUs   int
+	Memory int // in MB
+}
+
+var hardwareLimits = map[int]hardwareLimit{
+	8:  {CPUs: 8, Memory: 65536},
+	9:  {CPUs: 8, Memory: 65536},
+	10: {CPUs: 16, Memory: 65536},
+	11: {CPUs: 16, Memory: 65536},
+	12: {CPUs: 16, Memory: 65536},
+	14: {CPUs: 16, Memory: 65536},
+	16: {CPUs: 32, Memory: 65536},
+	18: {CPUs: 32, Memory: 131072},
+	19: {CPUs: 32, Memory: 131072},
+	20: {CPUs: 32, Memory: 131072},
+	21: {CPUs: 32, Memory: 131072},
+}
+
+// workstationHardwareVersions maps Workstation releases to the newest
+// hardware version they support, newest release first.
+var workstationHardwareVersions = []struct {
+	Major, Minor, Hardware int
+}{
+	{17, 5, 21},
+	{17, 0, 20},
+	{16, 2, 19},
+	{16, 0, 18},
+	{15, 0, 16},
+	{14, 0, 14},
+	{12, 0, 12},
+	{11, 0, 11},
+	{10, 0, 10},
+	{9, 0, 9},
+	{8, 0, 8},
+}
+
+// hardwareVersionFor returns the newest hardware version the Workstation
+// version supports.
+func hardwareVersionFor(version string) (int, error) {
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid Workstation version %q", version)
+	}
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+
+	for _, v := range workstationHardwareVersions {
+		if major > v.Major || major == v.Major && minor >= v.Minor {
+			return v.Hardware, nil
+		}
+	}
+	return 0, fmt.Errorf("VMware Workstation %s is not supported", version)
+}
+
+// installedHardwareVersion returns the newest hardware version the installed
+// Workstation supports, 0 when it cannot be detected.
+func installedHardwareVersion() int {
+	version, err := workstationVersion()
+	if err != nil {
+		log.Debugf("Unable to detect the Workstation version: %s", err)
+		return 0
+	}
+	hardware, err := hardwareVersionFor(version)
+	if err != nil {
+		log.Debugf("%s", err)
+		return 0
+	}
+	log.Debugf("VMware Workstation %s supports hardware version %d", version, hardware)
+	return hardware
+}
+
+// checkHardware picks the hardware version when none was configured and
+// checks the machine fits in its limits.
+func (d *Driver) checkHardware(installed int) error {
+	if d.HardwareVersion == 0 {
+		d.HardwareVersion = installed
+		if d.HardwareVersion == 0 {
+			d.HardwareVersion = defaultHardwareVersion
+		}
+	}
+
+	limit, ok := hardwareLimits[d.HardwareVersion]
+	if !ok {
+		var versions []int
+		for v := range hardwareLimits {
+			versions = append(versions, v)
+		}
+		sort.Ints(versions)
+		return fmt.Errorf("unsupported hardware version %d, it must be one of %v", d.HardwareVersion, versions)
+	}
+	if installed != 0 && d.HardwareVersion > installed {
+		return fmt.Errorf("hardware version %d is newer than the %d the installed VMware Workstation supports", d.HardwareVersion, installed)
+	}
+
+	if d.CPU > limit.CPUs {
+		log.Warnf("Hardware version %d supports up to %d CPUs, using %d", d.HardwareVersion, limit.CPUs, limit.CPUs)
+		d.CPU = limit.CPUs
+	}
+	if d.Memory > limit.Memory {
+		return fmt.Errorf("hardware version %d supports up to %d MB of memory, %d MB requested", d.HardwareVersion, limit.Memory, d.Memory)
+	}
+
+	if d.CoresPerSocket < 0 || d.CoresPerSocket > 0 && d.CPU%d.CoresPerSocket != 0 {
+		return fmt.Errorf("invalid cores per socket %d, it must divide the %d CPUs", d.CoresPerSocket, d.CPU)
+	}
+	if d.NestedVirtualization && d.HardwareVersion < nestedHardwareVersion {
+		return fmt.Errorf("nested virtualization needs hardware version %d or newer", nestedHardwareVersion)
+	}
+	if d.PerformanceCounters && d.HardwareVersion < nestedHardwareVersion {
+		return fmt.Errorf("performance counters need hardware version %d or newer", nestedHardwareVersion)
+	}
+
+	switch d.Firmware {
+	case firmwareBIOS, firmwareEFI:
+	case firmwareEFISecureBoot:
+		if d.HardwareVersion < secureBootHardwareVersion {
+			return fmt.Errorf("secure boot needs hardware version %d or newer", secureBootHardwareVersion)
+		}
+	default:
+		return fmt.Errorf("invalid firmware %q, it must be one of bios, efi or efi-secureboot", d.Firmware)
+	}
+
+	if !guestOSRegexp.MatchString(d.GuestOS) {
+		return fmt.Errorf("invalid guest OS %q", d.GuestOS)
+	}
+	return nil
+}
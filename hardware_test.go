@@ -0,0 +1,141 @@
+package vmwareworkstation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHardwareVersionFor(t *testing.T) {
+	for version, expected := range map[string]int{
+		"10.0.7": 10,
+		"12.5.9": 12,
+		"14.1.8": 14,
+		"15.5.6": 16,
+		"16.1.2": 18,
+		"16.2.5": 19,
+		"17.0.2": 20,
+		"17.5.0": 21,
+		"18.0.0": 21,
+		"8.0.6 ": 8,
+	} {
+		hardware, err := hardwareVersionFor(version)
+		assert.NoError(t, err, version)
+		assert.Equal(t, expected, hardware, version)
+	}
+
+	_, err := hardwareVersionFor("7.1.0")
+	assert.Error(t, err)
+	_, err = hardwareVersionFor("unknown")
+	assert.Error(t, err)
+}
+
+func TestCheckHardware(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.CPU = 64
+	driver.Memory = 4096
+
+	assert.NoError(t, driver.checkHardware(16))
+	assert.Equal(t, 16, driver.HardwareVersion)
+	assert.Equal(t, 32, driver.CPU)
+
+	driver.HardwareVersion, driver.CPU = 0, 64
+	assert.NoError(t, driver.checkHardware(0))
+	assert.Equal(t, defaultHardwareVersion, driver.HardwareVersion)
+	assert.Equal(t, 16, driver.CPU)
+}
+
+func TestCheckHardwareInvalid(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	driver.HardwareVersion = 13
+	assert.Contains(t, driver.checkHardware(0).Error(), "unsupported hardware version 13")
+
+	driver.HardwareVersion = 18
+	assert.Contains(t, driver.checkHardware(16).Error(), "newer than the 16")
+
+	driver.HardwareVersion, driver.Memory = 16, 131072
+	assert.Contains(t, driver.checkHardware(16).Error(), "up to 65536 MB")
+
+	driver.Memory, driver.GuestOS = 1024, `ubuntu-64"`
+	assert.Contains(t, driver.checkHardware(16).Error(), "invalid guest OS")
+}
+
+func TestCheckHardwareCPUTopology(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.CPU = 4
+	driver.CoresPerSocket = 2
+	driver.NestedVirtualization = true
+	driver.PerformanceCounters = true
+
+	assert.NoError(t, driver.checkHardware(16))
+
+	driver.CoresPerSocket = 3
+	assert.Contains(t, driver.checkHardware(16).Error(), "invalid cores per socket 3")
+
+	driver.CoresPerSocket, driver.HardwareVersion = 0, 8
+	assert.Contains(t, driver.checkHardware(0).Error(), "nested virtualization needs hardware version 9")
+
+	driver.NestedVirtualization = false
+	assert.Contains(t, driver.checkHardware(0).Error(), "performance counters need hardware version 9")
+}
+
+func TestRenderVMXCPUTopology(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	driver.CPU = 4
+	driver.CoresPerSocket = 2
+	driver.NestedVirtualization = true
+
+	content, err := driver.renderVMX()
+
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	assert.Equal(t, "2", settings["cpuid.corespersocket"])
+	assert.Equal(t, "TRUE", settings["vhv.enable"])
+	_, ok := settings["vpmc.enable"]
+	assert.False(t, ok)
+}
+
+func TestCheckHardwareFirmware(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.Firmware = firmwareEFISecureBoot
+
+	driver.HardwareVersion = 14
+	assert.NoError(t, driver.checkHardware(16))
+
+	driver.HardwareVersion = 12
+	assert.Contains(t, driver.checkHardware(16).Error(), "secure boot needs hardware version 14")
+
+	driver.Firmware = "uefi"
+	assert.Contains(t, driver.checkHardware(16).Error(), `invalid firmware "uefi"`)
+}
+
+func TestRenderVMXFirmware(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	driver.HardwareVersion = 16
+
+	content, err := driver.renderVMX()
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	_, ok := settings["firmware"]
+	assert.False(t, ok)
+
+	driver.Firmware = firmwareEFISecureBoot
+	content, err = driver.renderVMX()
+	assert.NoError(t, err)
+	settings = parseVMX(content)
+	assert.Equal(t, "efi", settings["firmware"])
+	assert.Equal(t, "TRUE", settings["uefi.secureboot.enabled"])
+	assert.Equal(t, "cdrom,hdd", settings["bios.bootorder"])
+	assert.Equal(t, "pvscsi", settings["scsi0.virtualdev"])
+
+	driver.Firmware, driver.HardwareVersion = firmwareEFI, 12
+	content, err = driver.renderVMX()
+	assert.NoError(t, err)
+	settings = parseVMX(content)
+	_, ok = settings["uefi.secureboot.enabled"]
+	assert.False(t, ok)
+	assert.Equal(t, "lsisas1068", settings["scsi0.virtualdev"])
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Events the host hooks run on.
+const (
+	HookPreCreate  = "pre-create"
+	HookPostCreate = "post-create"
+	HookPreStart   = "pre-start"
+	HookPostStart  = "post-start"
+	HookPreStop    = "pre-stop"
+	HookPostRemove = "post-remove"
+)
+
+var hookEvents = []string{HookPreCreate, HookPostCreate, HookPreStart, HookPostStart, HookPreStop, HookPostRemove}
+
+// Hook is a host command run on a machine lifecycle event.
+type Hook struct {
+	Event   string
+	Command string
+}
+
+// hookShell returns the host command running a hook.
+var hookShell = func(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// parseHook parses a hook of the form event=command.
+func parseHook(value string) (Hook, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return Hook{}, fmt.Errorf("invalid hook %q, the format is event=command", value)
+	}
+
+	event := strings.TrimSpace(parts[0])
+	for _, e := range hookEvents {
+		if e == event {
+			return Hook{Event: event, Command: parts[1]}, nil
+		}
+	}
+	return Hook{}, fmt.Errorf("invalid hook event %q, it must be one of %s", event, strings.Join(hookEvents, ", "))
+}
+
+func parseHooks(values []string) ([]Hook, error) {
+	var hooks []Hook
+	for _, value := range values {
+		hook, err := parseHook(value)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// hookEnv returns the environment variables describing the machine to the
+// hooks.
+func (d *Driver) hookEnv(event string) []string {
+	machineState := ""
+	if s, err := d.GetState(); err == nil {
+		machineState = s.String()
+	}
+
+	return []string{
+		"MACHINE_HOOK=" + event,
+		"MACHINE_NAME=" + d.MachineName,
+		"MACHINE_IP=" + d.IPAddress,
+		"MACHINE_VMX_PATH=" + d.vmxPath(),
+		"MACHINE_SSH_KEY_PATH=" + d.GetSSHKeyPath(),
+		"MACHINE_STATE=" + machineState,
+	}
+}
+
+// runHooks runs the hooks of the event in order and stops at the first one
+// that fails.
+func (d *Driver) runHooks(event string) error {
+	for _, hook := range d.Hooks {
+		if hook.Event != event {
+			continue
+		}
+
+		log.Infof("Running %s hook: %s", event, hook.Command)
+		cmd := hookShell(hook.Command)
+		cmd.Env = append(os.Environ(), d.hookEnv(event)...)
+		out, err := cmd.CombinedOutput()
+		log.Debugf("Output of the %s hook: %s", event, out)
+		if err != nil {
+			return fmt.Errorf("%s hook %q failed: %s: %s", event, hook.Command, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// runPostHooks runs the hooks of an event that happened already, a failure
+// cannot undo it so it is only reported.
+func (d *Driver) runPostHooks(event string) {
+	if err := d.runHooks(event); err != nil {
+		log.Warnf("%s", err)
+	}
+}
@@ -0,0 +1,64 @@
+package vmwareworkstation
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHooks(t *testing.T) {
+	hooks, err := parseHooks([]string{`pre-create=C:\hooks\check.cmd`, "post-start=echo a=b"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Hook{
+		{Event: HookPreCreate, Command: `C:\hooks\check.cmd`},
+		{Event: HookPostStart, Command: "echo a=b"},
+	}, hooks)
+}
+
+func TestParseHookInvalid(t *testing.T) {
+	for _, value := range []string{"pre-create", "pre-create=", "post-stop=echo"} {
+		_, err := parseHook(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	var commands []string
+	var runs []*exec.Cmd
+	origHookShell := hookShell
+	defer func() { hookShell = origHookShell }()
+	hookShell = func(command string) *exec.Cmd {
+		commands = append(commands, command)
+		// exit works the same in cmd and sh
+		cmd := origHookShell("exit 0")
+		if strings.Contains(command, "fail") {
+			cmd = origHookShell("exit 3")
+		}
+		runs = append(runs, cmd)
+		return cmd
+	}
+
+	driver := NewDriver("default", "path").(*Driver)
+	driver.IPAddress = "192.168.38.128"
+	driver.Hooks = []Hook{
+		{Event: HookPreStart, Command: "first"},
+		{Event: HookPostStart, Command: "other event"},
+		{Event: HookPreStart, Command: "fail"},
+		{Event: HookPreStart, Command: "never run"},
+	}
+
+	err := driver.runHooks(HookPreStart)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `pre-start hook "fail" failed`)
+	assert.Equal(t, []string{"first", "fail"}, commands)
+	env := strings.Join(runs[0].Env, "\n")
+	assert.Contains(t, env, "MACHINE_HOOK=pre-start")
+	assert.Contains(t, env, "MACHINE_NAME=default")
+	assert.Contains(t, env, "MACHINE_IP=192.168.38.128")
+	assert.Contains(t, env, "MACHINE_VMX_PATH="+driver.vmxPath())
+	assert.Contains(t, env, "MACHINE_SSH_KEY_PATH="+driver.GetSSHKeyPath())
+}
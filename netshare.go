@@ -0,0 +1,587 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnutils"
+	"github.com/docker/machine/libmachine/ssh"
+)
+
+const (
+	shareDriverHGFS  = "hgfs"
+	shareDriverNFS   = "nfs"
+	shareDriverSMB   = "smb"
+	shareDriverSSHFS = "sshfs"
+
+	smbCredentialsPath = "/etc/vmwareworkstation-smb-credentials"
+	sshfsKeyFilename   = "sshfs_id_rsa"
+	// sshfsKnownHostsFilename holds the host keys of the host OpenSSH
+	// server, in the machine dir and in ~/.ssh in the guest.
+	sshfsKnownHostsFilename = "sshfs_known_hosts"
+)
+
+// shareBackend exports shares from the host and mounts them in the guest
+// over SSH, it is used instead of VMware shared folders when another share
+// driver is selected.
+type shareBackend interface {
+	// prepare sets up what every share of the backend needs in the guest.
+	prepare(d *Driver) error
+	// export makes the share available from the host.
+	export(d *Driver, share Share) error
+	// mountCommand returns the guest command mounting the share.
+	mountCommand(d *Driver, share Share, hostIP string) string
+	// cleanup removes what prepare and export set up on the host.
+	cleanup(d *Driver) error
+}
+
+var ErrNFSServerNotFound = errors.New("the nfs share driver needs the Server for NFS role, which only Windows Server editions provide")
+
+var shareBackends = map[string]shareBackend{
+	shareDriverNFS:   nfsBackend{},
+	shareDriverSMB:   smbBackend{},
+	shareDriverSSHFS: sshfsBackend{},
+}
+
+// hostCommand runs a command on the host and returns its combined output.
+var hostCommand = func(name string, args ...string) (string, error) {
+	log.Debugf("executing: %v %v", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return string(out), err
+}
+
+func powershell(script string) error {
+	if out, err := hostCommand("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// exportName returns the name of the host export of the share, prefixed with
+// the machine name as the driver owns and removes it.
+func (d *Driver) exportName(share Share) string {
+	return d.MachineName + "-" + share.Name
+}
+
+// psExistingShare returns a PowerShell script storing the share returned by
+// get in $share, it fails when the share exports another directory.
+func psExistingShare(get, name, folder string) string {
+	return fmt.Sprintf(
+		"$share = %s -Name %s -ErrorAction SilentlyContinue; "+
+			"if ($share -and $share.Path.TrimEnd('\\') -ne %s.TrimEnd('\\')) { throw (%s + ' already exports ' + $share.Path) }; ",
+		get, psQuote(name), psQuote(folder), psQuote(name),
+	)
+}
+
+// psQuote quotes a string for a PowerShell single quoted literal.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// shQuote quotes a string for a POSIX shell single quoted literal.
+func shQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// hostIPForGuest returns the address of the host on the NAT network of the
+// guest, VMware always gives it the .1 address of the vmnet subnet.
+func hostIPForGuest(guestIP string) (string, error) {
+	ip := net.ParseIP(guestIP).To4()
+	if ip == nil {
+		return "", fmt.Errorf("unable to find the host address for guest address %q", guestIP)
+	}
+	return net.IPv4(ip[0], ip[1], ip[2], 1).String(), nil
+}
+
+// vmnetSubnet returns the vmnet subnet of the guest, VMware NAT and host-only
+// networks are /24.
+func vmnetSubnet(guestIP string) (string, error) {
+	ip := net.ParseIP(guestIP).To4()
+	if ip == nil {
+		return "", fmt.Errorf("unable to find the vmnet subnet of guest address %q", guestIP)
+	}
+	return fmt.Sprintf("%s/24", net.IPv4(ip[0], ip[1], ip[2], 0)), nil
+}
+
+// defaultShareUser returns the name of the current host user without its
+// Windows domain.
+func defaultShareUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	name := u.Username
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// mountNetworkShares exports the shares from the host and mounts them in
+// the guest over SSH with the configured share driver.
+func (d *Driver) mountNetworkShares() error {
+	backend, ok := shareBackends[d.ShareDriver]
+	if !ok {
+		return fmt.Errorf("unknown share driver %q", d.ShareDriver)
+	}
+
+	hostIP, err := hostIPForGuest(d.IPAddress)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Mounting Shared Folders over %s...", d.ShareDriver)
+	if err := backend.prepare(d); err != nil {
+		return err
+	}
+
+	for _, share := range d.Shares {
+		if _, err := os.Stat(share.ShareFolder); err != nil {
+			return fmt.Errorf("Unable to share %s: %s, check the host directory of the share", share.ShareFolder, ErrShareNotFound)
+		}
+
+		log.Infof("Exporting %s and mounting it on %s ...", share.ShareFolder, share.GuestFolder)
+		if err := backend.export(d, share); err != nil {
+			return fmt.Errorf("Unable to export %s over %s: %s", share.ShareFolder, d.ShareDriver, err)
+		}
+
+		command := fmt.Sprintf(
+			"sudo mkdir -p %s && { %s || %s; }",
+			shQuote(share.GuestFolder), mountedCommand(share.GuestFolder), backend.mountCommand(d, share, hostIP),
+		)
+		if err := executeSSHCommand(command, d); err != nil {
+			log.Debugf("Mount command failed: %s", err)
+		}
+		if err := executeSSHCommand(mountedCommand(share.GuestFolder), d); err != nil {
+			return fmt.Errorf("Shared folder %s is not mounted on %s over %s, run with --debug to see the mount errors", share.Name, share.GuestFolder, d.ShareDriver)
+		}
+	}
+
+	if command := d.compatLinkCommand(); command != "" {
+		if err := executeSSHCommand(command, d); err != nil {
+			log.Warnf("Unable to create compatibility link %s: %s", d.GuestCompatLink, err)
+		}
+	}
+
+	return nil
+}
+
+// cleanupNetworkShares removes the host side of the network shares, it is
+// run when the machine is removed.
+func (d *Driver) cleanupNetworkShares() error {
+	backend, ok := shareBackends[d.ShareDriver]
+	if !ok {
+		return nil
+	}
+	return backend.cleanup(d)
+}
+
+// installGuestPackage installs a boot2docker extension providing the
+// command if it is missing, other guests are expected to ship it.
+func installGuestPackage(command, extension string) string {
+	return fmt.Sprintf(
+		"command -v %s > /dev/null || { command -v tce-load > /dev/null && tce-load -wi %s > /dev/null; }",
+		command, extension,
+	)
+}
+
+type nfsBackend struct{}
+
+func (nfsBackend) prepare(d *Driver) error {
+	// Client editions of Windows only ship the NFS client
+	if err := powershell("if (-not (Get-Command New-NfsShare -ErrorAction SilentlyContinue)) { exit 1 }"); err != nil {
+		log.Debugf("New-NfsShare not found: %s", err)
+		return ErrNFSServerNotFound
+	}
+
+	// boot2docker ships the NFS client but does not start it
+	return executeSSHCommand("[ ! -x /usr/local/etc/init.d/nfs-client ] || sudo /usr/local/etc/init.d/nfs-client start", d)
+}
+
+// export creates the share without access for other hosts and grants the
+// guest access to it, root included as boot2docker mounts as root.
+func (nfsBackend) export(d *Driver, share Share) error {
+	permission := "readwrite"
+	if share.ReadOnly {
+		permission = "readonly"
+	}
+	name := d.exportName(share)
+	return powershell(psExistingShare("Get-NfsShare", name, share.ShareFolder) + fmt.Sprintf(
+		"if (-not $share) { New-NfsShare -Name %s -Path %s -Permission no-access -Authentication sys | Out-Null }; "+
+			"Grant-NfsSharePermission -Name %s -ClientName %s -ClientType host -Permission %s -AllowRootAccess $true",
+		psQuote(name), psQuote(share.ShareFolder),
+		psQuote(name), psQuote(d.IPAddress), permission,
+	))
+}
+
+func (nfsBackend) mountCommand(d *Driver, share Share, hostIP string) string {
+	options := "nolock,vers=3"
+	if share.ReadOnly {
+		options += ",ro"
+	}
+	return fmt.Sprintf("sudo mount -t nfs -o %s %s %s", options, shQuote(fmt.Sprintf("%s:/%s", hostIP, d.exportName(share))), shQuote(share.GuestFolder))
+}
+
+// cleanup removes the NFS shares of the machine.
+func (nfsBackend) cleanup(d *Driver) error {
+	for _, share := range d.Shares {
+		if err := powershell(psExistingShare("Get-NfsShare", d.exportName(share), share.ShareFolder) +
+			"if ($share) { Remove-NfsShare -Name $share.Name -Confirm:$false }"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type smbBackend struct{}
+
+func (smbBackend) prepare(d *Driver) error {
+	if err := executeSSHCommand(installGuestPackage("mount.cifs", "cifs-utils"), d); err != nil {
+		return err
+	}
+
+	// Keep the host password out of the mount command line and of the
+	// logged commands
+	credentials := fmt.Sprintf("username=%s\npassword=%s\n", d.ShareUser, d.SharePassword)
+	return executeSSHCommandInput(
+		fmt.Sprintf("sudo sh -c 'umask 077 && cat > %s'", smbCredentialsPath),
+		strings.NewReader(credentials), d,
+	)
+}
+
+func (smbBackend) export(d *Driver, share Share) error {
+	access := "-FullAccess"
+	if share.ReadOnly {
+		access = "-ReadAccess"
+	}
+	name := d.exportName(share)
+	return powershell(psExistingShare("Get-SmbShare", name, share.ShareFolder) + fmt.Sprintf(
+		"if (-not $share) { New-SmbShare -Name %s -Path %s %s %s | Out-Null }",
+		psQuote(name), psQuote(share.ShareFolder), access, psQuote(d.ShareUser),
+	))
+}
+
+func (smbBackend) mountCommand(d *Driver, share Share, hostIP string) string {
+	options := []string{"credentials=" + smbCredentialsPath, "vers=3.0"}
+	if share.ReadOnly {
+		options = append(options, "ro")
+	}
+	if d.ShareUID >= 0 {
+		options = append(options, fmt.Sprintf("uid=%d", d.ShareUID))
+	}
+	if d.ShareGID >= 0 {
+		options = append(options, fmt.Sprintf("gid=%d", d.ShareGID))
+	}
+	return fmt.Sprintf("sudo mount -t cifs -o %s %s %s", strings.Join(options, ","), shQuote(fmt.Sprintf("//%s/%s", hostIP, d.exportName(share))), shQuote(share.GuestFolder))
+}
+
+// cleanup removes the SMB shares of the machine.
+func (smbBackend) cleanup(d *Driver) error {
+	for _, share := range d.Shares {
+		if err := powershell(psExistingShare("Get-SmbShare", d.exportName(share), share.ShareFolder) +
+			"if ($share) { Remove-SmbShare -Name $share.Name -Force }"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sshfsBackend struct{}
+
+// prepare authorizes a machine specific key on the host OpenSSH server and
+// copies it to the guest.
+func (sshfsBackend) prepare(d *Driver) error {
+	keyPath := d.ResolveStorePath(sshfsKeyFilename)
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if err := ssh.GenerateSSHKey(keyPath); err != nil {
+			return err
+		}
+	}
+
+	pubKey, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return err
+	}
+	subnet, err := vmnetSubnet(d.IPAddress)
+	if err != nil {
+		return err
+	}
+	authorizedKeys, err := hostAuthorizedKeysPath(d.ShareUser)
+	if err != nil {
+		return err
+	}
+	if err := authorizeHostKey(authorizedKeys, sshfsAuthorizedKey(pubKey, subnet, readOnlyShares(d.Shares))); err != nil {
+		return fmt.Errorf("Unable to authorize the sshfs key in %s: %s", authorizedKeys, err)
+	}
+
+	hostIP, err := hostIPForGuest(d.IPAddress)
+	if err != nil {
+		return err
+	}
+	knownHosts, err := sshfsKnownHosts(hostIP)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(d.ResolveStorePath(sshfsKnownHostsFilename), knownHosts, 0644); err != nil {
+		return err
+	}
+
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	if err := executeSSHCommand(installGuestPackage("sshfs", "sshfs-fuse"), d); err != nil {
+		return err
+	}
+	// The key goes over stdin to stay out of the logged commands
+	if err := executeSSHCommandInput(fmt.Sprintf(
+		"mkdir -p ~/.ssh && (umask 077 && cat > ~/.ssh/%s)", sshfsKeyFilename,
+	), bytes.NewReader(key), d); err != nil {
+		return err
+	}
+	return executeSSHCommandInput(fmt.Sprintf(
+		"(umask 077 && cat > ~/.ssh/%s)", sshfsKnownHostsFilename,
+	), bytes.NewReader(knownHosts), d)
+}
+
+// hostSSHDir returns the directory of the host keys of the host OpenSSH
+// server.
+var hostSSHDir = func() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "ssh")
+	}
+	return "/etc/ssh"
+}
+
+// sshfsKnownHosts returns the known_hosts entries of the host keys of the
+// host OpenSSH server, the guest reaches it on hostIP.
+func sshfsKnownHosts(hostIP string) ([]byte, error) {
+	paths, _ := filepath.Glob(filepath.Join(hostSSHDir(), "ssh_host_*_key.pub"))
+	var b bytes.Buffer
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%s %s\n", hostIP, strings.TrimSpace(string(content)))
+	}
+	if b.Len() == 0 {
+		return nil, fmt.Errorf("No host key of the OpenSSH server found in %s, the sshfs share driver needs the OpenSSH Server Windows feature", hostSSHDir())
+	}
+	return b.Bytes(), nil
+}
+
+// sshfsAuthorizedKey returns the authorized_keys entry of the sshfs key, it
+// only allows SFTP from the vmnet subnet of the guest, read-only unless a
+// share is writable.
+func sshfsAuthorizedKey(pubKey []byte, subnet string, readOnly bool) string {
+	command := "internal-sftp"
+	if readOnly {
+		command += " -R"
+	}
+	return fmt.Sprintf(`restrict,command="%s",from="%s" %s`, command, subnet, strings.TrimSpace(string(pubKey)))
+}
+
+// readOnlyShares tells whether every share is read-only.
+func readOnlyShares(shares []Share) bool {
+	for _, share := range shares {
+		if !share.ReadOnly {
+			return false
+		}
+	}
+	return true
+}
+
+// hostUserIsAdmin tells whether the host user is a member of the local
+// Administrators group, whose keys the Windows OpenSSH server only reads from
+// administrators_authorized_keys.
+var hostUserIsAdmin = func(name string) (bool, error) {
+	if runtime.GOOS != "windows" {
+		return false, nil
+	}
+	out, err := hostCommand("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", fmt.Sprintf(
+		"if (Get-LocalGroupMember -SID 'S-1-5-32-544' | Where-Object { ($_.Name -split '\\\\')[-1] -eq %s }) { 'admin' }",
+		psQuote(name),
+	))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", err, strings.TrimSpace(out))
+	}
+	return strings.TrimSpace(out) == "admin", nil
+}
+
+// hostAuthorizedKeysPath returns the authorized_keys file the host OpenSSH
+// server reads for the user.
+func hostAuthorizedKeysPath(name string) (string, error) {
+	admin, err := hostUserIsAdmin(name)
+	if err != nil {
+		return "", err
+	}
+	if admin {
+		return filepath.Join(os.Getenv("ProgramData"), "ssh", "administrators_authorized_keys"), nil
+	}
+	return filepath.Join(mcnutils.GetHomeDir(), ".ssh", "authorized_keys"), nil
+}
+
+// authorizeHostKey adds the entry to the authorized_keys file, replacing any
+// entry of the same key.
+func authorizeHostKey(authorizedKeys, entry string) error {
+	lines, err := readAuthorizedKeysLines(authorizedKeys)
+	if err != nil {
+		return err
+	}
+	lines = removeKeyLines(lines, authorizedKeyBlob(entry))
+	lines = append(lines, entry)
+
+	_, statErr := os.Stat(authorizedKeys)
+	if err := os.MkdirAll(filepath.Dir(authorizedKeys), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(authorizedKeys, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+
+	// The OpenSSH server ignores an administrators_authorized_keys file
+	// other users can access
+	if os.IsNotExist(statErr) && filepath.Base(authorizedKeys) == "administrators_authorized_keys" {
+		if out, err := hostCommand("icacls.exe", authorizedKeys, "/inheritance:r", "/grant", "*S-1-5-32-544:F", "/grant", "*S-1-5-18:F"); err != nil {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// unauthorizeHostKey removes the entries of the key from the authorized_keys
+// file.
+func unauthorizeHostKey(authorizedKeys string, pubKey []byte) error {
+	lines, err := readAuthorizedKeysLines(authorizedKeys)
+	if err != nil || len(lines) == 0 {
+		return err
+	}
+	kept := removeKeyLines(lines, authorizedKeyBlob(string(pubKey)))
+	if len(kept) == len(lines) {
+		return nil
+	}
+
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return ioutil.WriteFile(authorizedKeys, []byte(content), 0600)
+}
+
+func readAuthorizedKeysLines(authorizedKeys string) ([]string, error) {
+	content, err := ioutil.ReadFile(authorizedKeys)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(string(content), "\r", "", -1), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// authorizedKeyBlob returns the base64 key of an authorized_keys entry, the
+// field following the key type.
+func authorizedKeyBlob(entry string) string {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return ""
+	}
+	for i, field := range fields[:len(fields)-1] {
+		if strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-") {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func removeKeyLines(lines []string, blob string) []string {
+	var kept []string
+	for _, line := range lines {
+		if blob != "" && authorizedKeyBlob(line) == blob {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// export has nothing to do, the host OpenSSH server serves every directory
+// the share user can read.
+func (sshfsBackend) export(d *Driver, share Share) error {
+	return nil
+}
+
+// cleanup removes the sshfs key from the host authorized_keys.
+func (sshfsBackend) cleanup(d *Driver) error {
+	pubKey, err := ioutil.ReadFile(d.ResolveStorePath(sshfsKeyFilename + ".pub"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	authorizedKeys, err := hostAuthorizedKeysPath(d.ShareUser)
+	if err != nil {
+		return err
+	}
+	return unauthorizeHostKey(authorizedKeys, pubKey)
+}
+
+func (sshfsBackend) mountCommand(d *Driver, share Share, hostIP string) string {
+	options := []string{
+		fmt.Sprintf("IdentityFile=/home/%s/.ssh/%s", d.SSHUser, sshfsKeyFilename),
+		"StrictHostKeyChecking=yes",
+		fmt.Sprintf("UserKnownHostsFile=/home/%s/.ssh/%s", d.SSHUser, sshfsKnownHostsFilename),
+		"allow_other",
+		"reconnect",
+	}
+	if share.ReadOnly {
+		options = append(options, "ro")
+	}
+	if d.ShareUID >= 0 {
+		options = append(options, fmt.Sprintf("uid=%d", d.ShareUID))
+	}
+	if d.ShareGID >= 0 {
+		options = append(options, fmt.Sprintf("gid=%d", d.ShareGID))
+	}
+	if d.ShareUmask != "" {
+		options = append(options, "umask="+d.ShareUmask)
+	}
+	return fmt.Sprintf(
+		"sudo sshfs -o %s %s %s",
+		strings.Join(options, ","), shQuote(fmt.Sprintf("%s@%s:%s", d.ShareUser, hostIP, sftpPath(share.ShareFolder))), shQuote(share.GuestFolder),
+	)
+}
+
+// sftpPath converts a Windows path to the form the Windows OpenSSH sftp
+// server expects, C:\Users becomes /C:/Users.
+func sftpPath(p string) string {
+	p = strings.Replace(p, `\`, "/", -1)
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/" + p
+	}
+	return p
+}
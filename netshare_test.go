@@ -0,0 +1,221 @@
+package vmwareworkstation
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestShareDriver returns a driver sharing a temporary directory with
+// the stand-in SSH server and recording the commands run on the host.
+func newTestShareDriver(t *testing.T, shareDriver string) (*Driver, *testSSHServer, *[]string, func()) {
+	shareFolder, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+
+	var hostCommands []string
+	origHostCommand := hostCommand
+	hostCommand = func(name string, args ...string) (string, error) {
+		hostCommands = append(hostCommands, name+" "+strings.Join(args, " "))
+		return "", nil
+	}
+
+	driver := NewDriver("default", shareFolder).(*Driver)
+	driver.IPAddress = "127.0.0.1"
+	driver.SSHPort = server.port()
+	driver.ShareDriver = shareDriver
+	driver.ShareUser = "me"
+	driver.SharePassword = "secret"
+	driver.Shares = []Share{{Name: "src", ShareFolder: shareFolder, GuestFolder: "/src", ReadOnly: true}}
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+
+	return driver, server, &hostCommands, func() {
+		hostCommand = origHostCommand
+		server.Close()
+		os.RemoveAll(shareFolder)
+	}
+}
+
+func TestMountNetworkSharesNFS(t *testing.T) {
+	driver, server, hostCommands, cleanup := newTestShareDriver(t, shareDriverNFS)
+	defer cleanup()
+
+	err := driver.mountNetworkShares()
+
+	assert.NoError(t, err)
+	assert.Len(t, *hostCommands, 2)
+	assert.Contains(t, (*hostCommands)[0], "Get-Command New-NfsShare")
+	assert.Contains(t, (*hostCommands)[1], "New-NfsShare -Name 'default-src'")
+	assert.Contains(t, (*hostCommands)[1], "-Permission no-access")
+	assert.Contains(t, (*hostCommands)[1], "Grant-NfsSharePermission -Name 'default-src' -ClientName '127.0.0.1' -ClientType host -Permission readonly")
+	assert.Contains(t, strings.Join(server.Commands(), "\n"), "sudo mount -t nfs -o nolock,vers=3,ro '127.0.0.1:/default-src' '/src'")
+}
+
+func TestMountNetworkSharesNFSClientEdition(t *testing.T) {
+	driver, _, hostCommands, cleanup := newTestShareDriver(t, shareDriverNFS)
+	defer cleanup()
+	hostCommand = func(name string, args ...string) (string, error) {
+		*hostCommands = append(*hostCommands, name+" "+strings.Join(args, " "))
+		return "", errors.New("exit status 1")
+	}
+
+	err := driver.mountNetworkShares()
+
+	assert.Equal(t, ErrNFSServerNotFound, err)
+	assert.Len(t, *hostCommands, 1)
+}
+
+func TestCleanupNetworkShares(t *testing.T) {
+	driver, _, hostCommands, cleanup := newTestShareDriver(t, shareDriverSMB)
+	defer cleanup()
+
+	assert.NoError(t, driver.cleanupNetworkShares())
+
+	assert.Len(t, *hostCommands, 1)
+	assert.Contains(t, (*hostCommands)[0], "Get-SmbShare -Name 'default-src'")
+	assert.Contains(t, (*hostCommands)[0], "if ($share) { Remove-SmbShare -Name $share.Name -Force }")
+}
+
+func TestMountNetworkSharesSMB(t *testing.T) {
+	driver, server, hostCommands, cleanup := newTestShareDriver(t, shareDriverSMB)
+	defer cleanup()
+
+	err := driver.mountNetworkShares()
+
+	assert.NoError(t, err)
+	assert.Len(t, *hostCommands, 1)
+	assert.Contains(t, (*hostCommands)[0], "already exports")
+	assert.Contains(t, (*hostCommands)[0], "New-SmbShare -Name 'default-src'")
+	assert.Contains(t, (*hostCommands)[0], "-ReadAccess 'me'")
+	commands := strings.Join(server.Commands(), "\n")
+	assert.Contains(t, server.Stdins(), "username=me\npassword=secret\n")
+	assert.NotContains(t, commands, "secret")
+	assert.Contains(t, commands, "sudo mount -t cifs -o credentials="+smbCredentialsPath+",vers=3.0,ro '//127.0.0.1/default-src' '/src'")
+}
+
+func TestPrepareSSHFS(t *testing.T) {
+	driver, server, _, cleanup := newTestShareDriver(t, shareDriverSSHFS)
+	defer cleanup()
+	home, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	origHostSSHDir := hostSSHDir
+	defer func() { hostSSHDir = origHostSSHDir }()
+	hostSSHDir = func() string { return home }
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(home, "ssh_host_ed25519_key.pub"), []byte("ssh-ed25519 AAAAhost root@host\n"), 0644))
+
+	err = sshfsBackend{}.prepare(driver)
+
+	assert.NoError(t, err)
+	key, err := ioutil.ReadFile(driver.ResolveStorePath(sshfsKeyFilename))
+	assert.NoError(t, err)
+	assert.Contains(t, server.Stdins(), string(key))
+	assert.NotContains(t, strings.Join(server.Commands(), "\n"), "PRIVATE KEY")
+}
+
+func TestMountNetworkSharesNotMounted(t *testing.T) {
+	driver, server, _, cleanup := newTestShareDriver(t, shareDriverNFS)
+	defer cleanup()
+	server.handler = func(command string) (string, uint32) {
+		if strings.HasPrefix(command, "grep") {
+			return "", 1
+		}
+		return "", 0
+	}
+
+	err := driver.mountNetworkShares()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not mounted on /src over nfs")
+}
+
+func TestHostIPForGuest(t *testing.T) {
+	ip, err := hostIPForGuest("192.168.38.128")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.38.1", ip)
+}
+
+func TestSFTPPath(t *testing.T) {
+	assert.Equal(t, "/C:/Users/me", sftpPath(`C:\Users\me`))
+	assert.Equal(t, "/home/me", sftpPath("/home/me"))
+}
+
+func TestVMnetSubnet(t *testing.T) {
+	subnet, err := vmnetSubnet("192.168.38.128")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.38.0/24", subnet)
+}
+
+func TestAuthorizeHostKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	authorizedKeys := filepath.Join(dir, ".ssh", "authorized_keys")
+	pubKey := []byte("ssh-rsa AAAAmachine\n")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(authorizedKeys), 0700))
+	assert.NoError(t, ioutil.WriteFile(authorizedKeys, []byte("ssh-ed25519 AAAAother me@laptop\r\nssh-rsa AAAAmachine\n"), 0600))
+
+	entry := sshfsAuthorizedKey(pubKey, "192.168.38.0/24", false)
+	assert.NoError(t, authorizeHostKey(authorizedKeys, entry))
+	assert.NoError(t, authorizeHostKey(authorizedKeys, entry))
+
+	content, err := ioutil.ReadFile(authorizedKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAAother me@laptop\n"+`restrict,command="internal-sftp",from="192.168.38.0/24" ssh-rsa AAAAmachine`+"\n", string(content))
+
+	assert.NoError(t, unauthorizeHostKey(authorizedKeys, pubKey))
+
+	content, err = ioutil.ReadFile(authorizedKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAAother me@laptop\n", string(content))
+}
+
+func TestSSHFSAuthorizedKeyReadOnly(t *testing.T) {
+	pubKey := []byte("ssh-rsa AAAAmachine\n")
+	shares := []Share{{Name: "src", ReadOnly: true}, {Name: "docs", ReadOnly: true}}
+
+	assert.True(t, readOnlyShares(shares))
+	assert.Equal(t, `restrict,command="internal-sftp -R",from="192.168.38.0/24" ssh-rsa AAAAmachine`, sshfsAuthorizedKey(pubKey, "192.168.38.0/24", readOnlyShares(shares)))
+
+	shares[1].ReadOnly = false
+	assert.False(t, readOnlyShares(shares))
+}
+
+func TestSSHFSKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	origHostSSHDir := hostSSHDir
+	defer func() { hostSSHDir = origHostSSHDir }()
+	hostSSHDir = func() string { return dir }
+
+	_, err = sshfsKnownHosts("192.168.38.1")
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ssh_host_ed25519_key.pub"), []byte("ssh-ed25519 AAAAhost root@host\r\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ssh_host_ed25519_key"), []byte("private"), 0600))
+
+	knownHosts, err := sshfsKnownHosts("192.168.38.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.38.1 ssh-ed25519 AAAAhost root@host\n", string(knownHosts))
+}
+
+func TestSSHFSMountCommand(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.ShareUser = "me"
+
+	command := sshfsBackend{}.mountCommand(driver, Share{Name: "src", ShareFolder: `C:\src`, GuestFolder: "/src"}, "192.168.38.1")
+
+	assert.Contains(t, command, "StrictHostKeyChecking=yes,UserKnownHostsFile=/home/docker/.ssh/sshfs_known_hosts,")
+	assert.Contains(t, command, "'me@192.168.38.1:/C:/src' '/src'")
+}
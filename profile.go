@@ -0,0 +1,99 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultProfile = "default"
+
+// vmxProfile is a coherent set of VMX changes applied on top of the vmx
+// template.
+type vmxProfile struct {
+	// Remove holds the keys dropped from the VMX file.
+	Remove []string
+	// Set holds the keys added or replaced in the VMX file.
+	Set map[string]string
+}
+
+var vmxProfiles = map[string]vmxProfile{
+	defaultProfile: {},
+	// performance trades memory and features for less host I/O: memory is
+	// not backed by a file, pages are not scanned for sharing and the guest
+	// sees an SSD.
+	"performance": {
+		Remove: []string{"sound.pciSlotNumber", "usb.pciSlotNumber"},
+		Set: map[string]string{
+			"mainMem.useNamedFile":                "FALSE",
+			"sched.mem.pshare.enable":             "FALSE",
+			"MemTrimRate":                         "0",
+			"MemAllowAutoScaleDown":               "FALSE",
+			"prefvmx.useRecommendedLockedMemSize": "TRUE",
+			"mem.hotadd":                          "FALSE",
+			"scsi0:0.virtualSSD":                  "1",
+			"sound.present":                       "FALSE",
+			"usb.present":                         "FALSE",
+		},
+	},
+	// minimal keeps the smallest device set the driver needs.
+	"minimal": {
+		Remove: []string{"sound.pciSlotNumber", "usb.pciSlotNumber", "hpet0.present"},
+		Set: map[string]string{
+			"mainMem.useNamedFile": "FALSE",
+			"mem.hotadd":           "FALSE",
+			"sound.present":        "FALSE",
+			"usb.present":          "FALSE",
+			"serial0.present":      "FALSE",
+			"parallel0.present":    "FALSE",
+		},
+	},
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range vmxProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkProfile(name string) error {
+	if _, ok := vmxProfiles[name]; !ok {
+		return fmt.Errorf("invalid profile %q, it must be one of %s", name, strings.Join(profileNames(), ", "))
+	}
+	return nil
+}
+
+// applyProfile applies the profile to the VMX content.
+func applyProfile(content []byte, name string) []byte {
+	profile := vmxProfiles[name]
+	return mergeVMX(removeVMX(content, profile.Remove), profile.Set)
+}
+
+// removeVMX drops the keys from the VMX content.
+func removeVMX(content []byte, keys []string) []byte {
+	if len(keys) == 0 {
+		return content
+	}
+
+	remove := map[string]bool{}
+	for _, key := range keys {
+		remove[strings.ToLower(key)] = true
+	}
+
+	var b bytes.Buffer
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if m := vmxLineRegexp.FindStringSubmatch(line); m != nil && remove[strings.ToLower(m[1])] {
+			continue
+		}
+		b.WriteString(line)
+	}
+	return b.Bytes()
+}
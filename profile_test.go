@@ -0,0 +1,47 @@
+package vmwareworkstation
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+func TestRenderVMXProfiles(t *testing.T) {
+	for _, profile := range profileNames() {
+		driver := NewDriver("default", "path").(*Driver)
+		// Keep the paths out of the golden files, they depend on the host
+		driver.ISO = isoFilename
+		driver.HardwareVersion = 16
+		driver.CPU = 2
+		driver.Profile = profile
+
+		content, err := driver.renderVMX()
+		assert.NoError(t, err, profile)
+
+		golden := filepath.Join("testdata", "profiles", profile+".vmx")
+		if *updateGolden {
+			assert.NoError(t, ioutil.WriteFile(golden, content, 0644))
+		}
+		expected, err := ioutil.ReadFile(golden)
+		assert.NoError(t, err, profile)
+		assert.Equal(t, string(expected), string(content), profile)
+	}
+}
+
+func TestCheckProfile(t *testing.T) {
+	assert.NoError(t, checkProfile("performance"))
+	err := checkProfile("turbo")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "default, minimal, performance")
+}
+
+func TestRemoveVMX(t *testing.T) {
+	content := []byte("usb.present = \"TRUE\"\nUSB.pciSlotNumber = \"32\"\nmemsize = \"1024\"\n")
+
+	assert.Equal(t, "memsize = \"1024\"\n", string(removeVMX(content, []string{"usb.present", "usb.pciSlotNumber"})))
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// serialLogFilename is the file of the machine dir serial0 writes to,
+	// relative paths in a VMX file are resolved from its directory.
+	serialLogFilename = "serial.log"
+
+	serialLogTailLines = 30
+	serialLogTailBytes = 16 * 1024
+)
+
+// serialConsoleCommand adds the serial console to the kernel command line of
+// GRUB based guests, boot2docker already logs to it. It takes effect on the
+// next boot.
+const serialConsoleCommand = `if [ -f /etc/default/grub ] && ! grep -q 'console=ttyS0' /etc/default/grub; then
+  sudo sed -i 's/^GRUB_CMDLINE_LINUX="/GRUB_CMDLINE_LINUX="console=ttyS0,115200n8 console=tty0 /' /etc/default/grub
+  if command -v update-grub > /dev/null; then
+    sudo update-grub
+  elif command -v grub2-mkconfig > /dev/null; then
+    sudo grub2-mkconfig -o /boot/grub2/grub.cfg
+  fi
+fi`
+
+func (d *Driver) serialLogPath() string {
+	return d.ResolveStorePath(serialLogFilename)
+}
+
+// configureSerialConsole points the guest kernel console to the serial port
+// when the guest allows it, a failure is only a warning.
+func (d *Driver) configureSerialConsole() {
+	if !d.SerialLog {
+		return
+	}
+	if err := executeSSHCommand(serialConsoleCommand, d); err != nil {
+		log.Warnf("Unable to configure the serial console of the guest: %s", err)
+	}
+}
+
+// withSerialLog appends the tail of the serial console log to a boot error.
+func (d *Driver) withSerialLog(err error) error {
+	if !d.SerialLog {
+		return err
+	}
+
+	tail, tailErr := tailFile(d.serialLogPath(), serialLogTailLines)
+	if tailErr != nil {
+		log.Debugf("Unable to read the serial console log: %s", tailErr)
+		return err
+	}
+	if tail == "" {
+		return fmt.Errorf("%s, the serial console log %s is empty", err, d.serialLogPath())
+	}
+	return fmt.Errorf("%s, last lines of the serial console log %s:\n%s", err, d.serialLogPath(), tail)
+}
+
+// tailFile returns at most the last n lines of the end of the file.
+func tailFile(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - serialLogTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	content := strings.Replace(string(buf), "\r", "", -1)
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if offset > 0 && len(lines) > 1 {
+		// The first line was cut by the offset
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
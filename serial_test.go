@@ -0,0 +1,64 @@
+package vmwareworkstation
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderVMXSerialLog(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	driver.SerialLog = true
+
+	content, err := driver.renderVMX()
+
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	assert.Equal(t, "TRUE", settings["serial0.present"])
+	assert.Equal(t, "file", settings["serial0.filetype"])
+	assert.Equal(t, serialLogFilename, settings["serial0.filename"])
+
+	driver.Profile = "minimal"
+	_, err = driver.renderVMX()
+	assert.Contains(t, err.Error(), "a serial port using serial.log")
+}
+
+func TestTailFile(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+
+	var lines []string
+	for i := 1; i <= 5000; i++ {
+		lines = append(lines, fmt.Sprintf("[    %d.000000] line %d\r", i, i))
+	}
+	assert.NoError(t, ioutil.WriteFile(driver.serialLogPath(), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+
+	tail, err := tailFile(driver.serialLogPath(), 3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[    4998.000000] line 4998\n[    4999.000000] line 4999\n[    5000.000000] line 5000", tail)
+}
+
+func TestWithSerialLog(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+	timeout := errors.New("Machine didn't return an IP after 120 seconds, aborting")
+
+	assert.Equal(t, timeout, driver.withSerialLog(timeout))
+
+	driver.SerialLog = true
+	assert.Equal(t, timeout, driver.withSerialLog(timeout))
+
+	assert.NoError(t, ioutil.WriteFile(driver.serialLogPath(), []byte("Booting\nKernel panic - not syncing: VFS\n"), 0644))
+	err := driver.withSerialLog(timeout)
+	assert.Contains(t, err.Error(), "after 120 seconds, aborting, last lines of the serial console log")
+	assert.True(t, strings.HasSuffix(err.Error(), "\nBooting\nKernel panic - not syncing: VFS"))
+}
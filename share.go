@@ -0,0 +1,477 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	bootlocalPath        = "/var/lib/boot2docker/bootlocal.sh"
+	guestSharesScript    = "/var/lib/boot2docker/vmwareworkstation-shares.sh"
+	sharesScriptFilename = "shares.sh"
+	mountUnitMarker      = "# Managed by docker-machine-vmwareworkstation"
+
+	mountAttempts = 10
+	toolsTimeout  = 60
+)
+
+var (
+	ErrShareNotFound    = errors.New("shared folder does not exist on host")
+	ErrToolsNotRunning  = errors.New("VMware Tools are not running in the guest")
+	ErrGuestCredentials = errors.New("guest user name or password is invalid")
+	ErrVmhgfsNotFound   = errors.New("neither vmhgfs-fuse nor the vmhgfs kernel module is available in the guest")
+)
+
+// Share is a host directory exposed to the guest through VMware shared folders.
+type Share struct {
+	Name        string
+	ShareFolder string
+	GuestFolder string
+	ReadOnly    bool
+}
+
+// defaultShares returns the share mounted when no share is given on the
+// command line, it mimics the home directory share of the other drivers.
+func defaultShares() ([]Share, string) {
+	switch runtime.GOOS {
+	case "linux": // TODO Test linux working
+		return []Share{{Name: "Home", ShareFolder: "/home", GuestFolder: "/Users"}}, ""
+	case "windows":
+		return []Share{{Name: "Users", ShareFolder: `C:\Users\`, GuestFolder: "/Users"}}, "/c/Users"
+	}
+	return nil, ""
+}
+
+// migrateShares turns the share of a machine created before the driver
+// supported several shares into Shares.
+func (d *Driver) migrateShares() {
+	if len(d.Shares) > 0 || d.ShareFolder == "" {
+		return
+	}
+	log.Debugf("Migrating the %s share of %s", d.ShareName, d.MachineName)
+	d.Shares = []Share{{Name: d.ShareName, ShareFolder: d.ShareFolder, GuestFolder: d.GuestFolder}}
+}
+
+// parseShare parses a share in the name=hostdir:guestdir[:ro] format.
+// The host directory can contain a Windows drive letter, so the guest
+// directory is everything after the last colon.
+func parseShare(share string) (Share, error) {
+	s := Share{}
+	invalid := fmt.Errorf("invalid share %q, format is name=hostdir:guestdir[:ro]", share)
+
+	split := strings.SplitN(share, "=", 2)
+	if len(split) != 2 || split[0] == "" {
+		return s, invalid
+	}
+	s.Name = split[0]
+
+	dirs := split[1]
+	if strings.HasSuffix(dirs, ":ro") {
+		s.ReadOnly = true
+		dirs = strings.TrimSuffix(dirs, ":ro")
+	} else {
+		dirs = strings.TrimSuffix(dirs, ":rw")
+	}
+
+	i := strings.LastIndex(dirs, ":")
+	if i < 1 {
+		return s, invalid
+	}
+	s.ShareFolder, s.GuestFolder = dirs[:i], dirs[i+1:]
+	if !path.IsAbs(s.GuestFolder) {
+		return s, fmt.Errorf("invalid share %q, guest directory must be an absolute path", share)
+	}
+
+	return s, nil
+}
+
+// parseShares parses every --vmwareworkstation-share value and makes sure
+// share names are unique.
+func parseShares(values []string) ([]Share, error) {
+	shares := make([]Share, 0, len(values))
+	names := map[string]bool{}
+	for _, value := range values {
+		share, err := parseShare(value)
+		if err != nil {
+			return nil, err
+		}
+		if names[share.Name] {
+			return nil, fmt.Errorf("share name %q is used more than once", share.Name)
+		}
+		names[share.Name] = true
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// parseShareFolder parses the legacy name:dir format of
+// --vmwareworkstation-share-folder.
+func parseShareFolder(shareFolder string) (string, string, error) {
+	split := strings.SplitN(shareFolder, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", fmt.Errorf("invalid share folder %q, format is name:dir", shareFolder)
+	}
+	return split[0], split[1], nil
+}
+
+func mountSharedFolder(d *Driver) error {
+	log.Infof("Mounting Shared Folders...")
+	if err := d.waitForTools(); err != nil {
+		return err
+	}
+
+	for _, share := range d.Shares {
+		if err := d.addSharedFolder(share); err != nil {
+			return err
+		}
+		if err := d.mountShare(share); err != nil {
+			return err
+		}
+	}
+
+	if command := d.compatLinkCommand(); command != "" {
+		log.Debug(command)
+		if err := d.runScriptInGuest(command); err != nil {
+			log.Warnf("Unable to create compatibility link %s: %s", d.GuestCompatLink, err)
+		}
+	}
+
+	// Remount the shares when the guest reboots on its own
+	return d.syncBootlocal()
+}
+
+// addSharedFolder adds the share to the VM configuration on the host.
+func (d *Driver) addSharedFolder(share Share) error {
+	if _, err := os.Stat(share.ShareFolder); err != nil {
+		return fmt.Errorf("Unable to share %s: %s, check the host directory of the share", share.ShareFolder, ErrShareNotFound)
+	}
+
+	// Add Share folder config so VMWare
+	log.Infof("Adding shared folder %s and mapping to %s ...", share.ShareFolder, share.GuestFolder)
+	d.vmrunInGuest("addSharedFolder", share.Name, share.ShareFolder)
+
+	// addSharedFolder always creates a writable share and fails if the
+	// share survived in the VMX, setting the state covers both cases
+	mode := "writable"
+	if share.ReadOnly {
+		mode = "readonly"
+	}
+	if stdout, _, err := d.vmrunInGuest("setSharedFolderState", share.Name, share.ShareFolder, mode); err != nil {
+		return fmt.Errorf("Unable to add shared folder %s: %s", share.Name, strings.TrimSpace(stdout))
+	}
+
+	return nil
+}
+
+// mountShare mounts the share in the guest and checks /proc/mounts
+// afterwards, retrying while the guest finishes booting.
+func (d *Driver) mountShare(share Share) error {
+	command := d.mountCommand(share)
+	log.Debug(command)
+	for i := 1; i <= mountAttempts; i++ {
+		if err := d.runScriptInGuest(command); err != nil {
+			log.Debugf("Mount of %s failed %d/%d: %s", share.Name, i, mountAttempts, err)
+		}
+		if err := d.runScriptInGuest(mountedCommand(share.GuestFolder)); err == nil {
+			return nil
+		}
+		time.Sleep(3 * time.Second)
+	}
+
+	return d.diagnoseMount(share)
+}
+
+// waitForTools waits for VMware Tools to run in the guest and checks the
+// guest credentials, every other guest operation depends on both.
+func (d *Driver) waitForTools() error {
+	for i := 1; i <= toolsTimeout/2; i++ {
+		stdout, _, err := vmrun("checkToolsState", d.vmxPath())
+		if err == nil && strings.TrimSpace(stdout) == "running" {
+			break
+		}
+		// Old vmrun versions do not know checkToolsState, fall back to the
+		// credentials check below which needs Tools as well
+		if strings.Contains(stdout, "Unrecognized command") {
+			break
+		}
+		if i == toolsTimeout/2 {
+			return d.withVMwareLog(d.withSerialLog(fmt.Errorf("%s after %d seconds, make sure open-vm-tools are installed in the guest image", ErrToolsNotRunning, toolsTimeout)))
+		}
+		log.Debugf("VMware Tools not running yet %d/%d: %s", i, toolsTimeout/2, strings.TrimSpace(stdout))
+		time.Sleep(2 * time.Second)
+	}
+
+	return d.checkGuestCredentials()
+}
+
+// checkGuestCredentials runs a no-op in the guest to validate the guest
+// credentials.
+func (d *Driver) checkGuestCredentials() error {
+	return d.runScriptInGuest("true")
+}
+
+// guestError turns the output of a failed guest operation into one of the
+// known guest errors when possible.
+func guestError(stdout string, err error) error {
+	stdout = strings.TrimSpace(stdout)
+	switch {
+	case strings.Contains(stdout, "Invalid user name or password"):
+		return fmt.Errorf("%s, check --vmwareworkstation-guest-user and --vmwareworkstation-guest-password", ErrGuestCredentials)
+	case strings.Contains(stdout, "VMware Tools are not running"):
+		return fmt.Errorf("%s, make sure open-vm-tools are installed in the guest image", ErrToolsNotRunning)
+	}
+	return fmt.Errorf("%s: %s", err, stdout)
+}
+
+// diagnoseMount finds out why a share could not be mounted.
+func (d *Driver) diagnoseMount(share Share) error {
+	if _, err := os.Stat(share.ShareFolder); err != nil {
+		return fmt.Errorf("Unable to mount %s: %s, check the host directory of the share", share.Name, ErrShareNotFound)
+	}
+
+	if err := d.checkGuestCredentials(); err != nil {
+		return fmt.Errorf("Unable to mount %s: %s", share.Name, err)
+	}
+
+	vmhgfs := "[ -f /usr/local/bin/vmhgfs-fuse ] || [ -f /usr/bin/vmhgfs-fuse ] || " +
+		"grep -qw vmhgfs /proc/filesystems || sudo modprobe vmhgfs"
+	if err := d.runScriptInGuest(vmhgfs); err != nil {
+		return fmt.Errorf("Unable to mount %s: %s, install open-vm-tools with shared folder support in the guest image", share.Name, ErrVmhgfsNotFound)
+	}
+
+	return fmt.Errorf("Shared folder %s is not mounted on %s, run with --debug to see the mount errors", share.Name, share.GuestFolder)
+}
+
+// mountCommand returns a shell command mounting the share in the guest
+// unless it is mounted already.
+func (d *Driver) mountCommand(share Share) string {
+	fuseOpts, mountOpts := d.mountOptions(share)
+	host, guest := shQuote(".host:/"+share.Name), shQuote(share.GuestFolder)
+	return fmt.Sprintf(
+		"%s || { "+
+			"sudo mkdir -p %s && "+
+			"if [ -f /usr/local/bin/vmhgfs-fuse ]; "+
+			"then sudo /usr/local/bin/vmhgfs-fuse %s%s %s; "+
+			"else sudo mount -t vmhgfs %s%s %s; fi; }",
+		mountedCommand(share.GuestFolder),
+		guest,
+		optionsArg(fuseOpts), host, guest,
+		optionsArg(mountOpts), host, guest,
+	)
+}
+
+// mountedCommand returns a shell command succeeding when a file system is
+// mounted on the guest directory. /proc/mounts escapes spaces, tabs, line
+// breaks and backslashes of the mount points in octal.
+func mountedCommand(guestFolder string) string {
+	escaped := strings.NewReplacer(`\`, `\134`, " ", `\040`, "\t", `\011`, "\n", `\012`).Replace(guestFolder)
+	return fmt.Sprintf("grep -qF %s /proc/mounts", shQuote(" "+escaped+" "))
+}
+
+// compatLinkCommand returns a shell command adding the compatibility
+// symlink to the first share, or nothing if there is no link to create.
+func (d *Driver) compatLinkCommand() string {
+	if d.GuestCompatLink == "" || len(d.Shares) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(
+		"[ -e %s ] || { sudo mkdir -p %s && sudo ln -s %s %s; }",
+		shQuote(d.GuestCompatLink),
+		shQuote(path.Dir(d.GuestCompatLink)),
+		shQuote(d.Shares[0].GuestFolder),
+		shQuote(d.GuestCompatLink),
+	)
+}
+
+// mountOptions returns the vmhgfs-fuse and the vmhgfs kernel module mount
+// options of a share, the latter has no umask option so it is applied
+// through fmask and dmask.
+func (d *Driver) mountOptions(share Share) (string, string) {
+	var fuse, kernel []string
+	if d.ShareMountOptions != "" {
+		fuse = append(fuse, d.ShareMountOptions)
+	}
+	if share.ReadOnly {
+		fuse = append(fuse, "ro")
+		kernel = append(kernel, "ro")
+	}
+	if d.ShareUID >= 0 {
+		fuse = append(fuse, fmt.Sprintf("uid=%d", d.ShareUID))
+		kernel = append(kernel, fmt.Sprintf("uid=%d", d.ShareUID))
+	}
+	if d.ShareGID >= 0 {
+		fuse = append(fuse, fmt.Sprintf("gid=%d", d.ShareGID))
+		kernel = append(kernel, fmt.Sprintf("gid=%d", d.ShareGID))
+	}
+	if d.ShareUmask != "" {
+		fuse = append(fuse, "umask="+d.ShareUmask)
+		kernel = append(kernel, "fmask="+d.ShareUmask, "dmask="+d.ShareUmask)
+	}
+
+	return strings.Join(fuse, ","), strings.Join(kernel, ",")
+}
+
+func optionsArg(options string) string {
+	if options == "" {
+		return ""
+	}
+	return fmt.Sprintf("-o %s ", options)
+}
+
+// sharesScript returns the script boot2docker runs from bootlocal.sh to
+// mount the shares on every boot.
+func (d *Driver) sharesScript() string {
+	var b bytes.Buffer
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by docker-machine-vmwareworkstation, do not edit\n")
+	for _, share := range d.Shares {
+		b.WriteString(d.mountCommand(share) + "\n")
+	}
+	if command := d.compatLinkCommand(); command != "" {
+		b.WriteString(command + "\n")
+	}
+	return b.String()
+}
+
+// syncBootlocal installs the shares script in the persistent boot2docker
+// partition and hooks it into bootlocal.sh. The script is rewritten every
+// time so it follows the configured shares.
+func (d *Driver) syncBootlocal() error {
+	log.Debugf("Installing %s...", guestSharesScript)
+
+	hostScript := d.ResolveStorePath(sharesScriptFilename)
+	if err := ioutil.WriteFile(hostScript, []byte(d.sharesScript()), 0644); err != nil {
+		return err
+	}
+
+	tmpScript := "/tmp/" + sharesScriptFilename
+	if stdout, _, err := d.vmrunInGuest("CopyFileFromHostToGuest", hostScript, tmpScript); err != nil {
+		return fmt.Errorf("Unable to copy %s to the guest: %s", hostScript, strings.TrimSpace(stdout))
+	}
+
+	hook := "/bin/sh " + guestSharesScript
+	command := fmt.Sprintf(
+		"sudo mv %s %s && sudo chmod 755 %s && "+
+			"{ [ -f %s ] || echo '#!/bin/sh' | sudo tee %s > /dev/null; } && "+
+			"sudo chmod 755 %s && "+
+			"{ grep -qF '%s' %s || echo '%s' | sudo tee -a %s > /dev/null; }",
+		tmpScript, guestSharesScript, guestSharesScript,
+		bootlocalPath, bootlocalPath,
+		bootlocalPath,
+		hook, bootlocalPath, hook, bootlocalPath,
+	)
+	if err := d.runScriptInGuest(command); err != nil {
+		return fmt.Errorf("Unable to install %s: %s", guestSharesScript, err)
+	}
+
+	return nil
+}
+
+// mountUnit returns the name and the content of the systemd mount unit
+// mounting the share on cloud-init guests.
+func (d *Driver) mountUnit(share Share) (string, string) {
+	fuseOpts, _ := d.mountOptions(share)
+
+	var b bytes.Buffer
+	b.WriteString(mountUnitMarker + "\n")
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=VMware shared folder %s\n", share.Name)
+	b.WriteString("[Mount]\n")
+	fmt.Fprintf(&b, "What=.host:/%s\n", share.Name)
+	fmt.Fprintf(&b, "Where=%s\n", share.GuestFolder)
+	b.WriteString("Type=fuse.vmhgfs-fuse\n")
+	if fuseOpts != "" {
+		fmt.Fprintf(&b, "Options=%s\n", fuseOpts)
+	}
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+
+	return systemdEscapePath(share.GuestFolder) + ".mount", b.String()
+}
+
+// systemdEscapePath mimics systemd-escape --path, mount units have to be
+// named after the escaped mount point.
+func systemdEscapePath(p string) string {
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "" {
+		return "-"
+	}
+
+	var b bytes.Buffer
+	for i, c := range []byte(p) {
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '_', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// syncMountUnits adds the shares to the VM and installs a systemd mount unit
+// for each of them over SSH. Units installed for shares that are no longer
+// configured are disabled and removed.
+func (d *Driver) syncMountUnits() error {
+	log.Infof("Installing shared folder mount units...")
+	var units []string
+	for _, share := range d.Shares {
+		if err := d.addSharedFolder(share); err != nil {
+			return err
+		}
+
+		unit, content := d.mountUnit(share)
+		units = append(units, unit)
+		command := fmt.Sprintf(
+			"sudo mkdir -p %s && sudo tee '/etc/systemd/system/%s' > /dev/null <<'EOF'\n%sEOF",
+			shQuote(share.GuestFolder), unit, content,
+		)
+		if err := executeSSHCommand(command, d); err != nil {
+			return fmt.Errorf("Unable to install mount unit %s: %s", unit, err)
+		}
+	}
+
+	command := fmt.Sprintf(
+		"for f in $(sudo grep -l '^%s' /etc/systemd/system/*.mount 2>/dev/null); do "+
+			"u=$(basename \"$f\"); case \" %s \" in *\" $u \"*) ;; "+
+			"*) sudo systemctl disable --now \"$u\"; sudo rm -f \"$f\";; esac; done; "+
+			"sudo systemctl daemon-reload",
+		mountUnitMarker, strings.Join(units, " "),
+	)
+	if err := executeSSHCommand(command, d); err != nil {
+		return err
+	}
+
+	for _, unit := range units {
+		if err := executeSSHCommand(fmt.Sprintf("sudo systemctl enable '%s' && sudo systemctl restart '%s'", unit, unit), d); err != nil {
+			return fmt.Errorf("Unable to mount %s: %s", unit, err)
+		}
+	}
+
+	return nil
+}
+
+// runScriptInGuest runs a shell command in the guest through VMware Tools.
+func (d *Driver) runScriptInGuest(command string) error {
+	stdout, _, err := d.vmrunInGuest("runScriptInGuest", "/bin/sh", command)
+	if err != nil {
+		return guestError(stdout, err)
+	}
+	return nil
+}
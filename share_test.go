@@ -0,0 +1,137 @@
+package vmwareworkstation
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShare(t *testing.T) {
+	tests := []struct {
+		value string
+		share Share
+	}{
+		{"src=/home/me/src:/src", Share{Name: "src", ShareFolder: "/home/me/src", GuestFolder: "/src"}},
+		{`Users=C:\Users:/c/Users`, Share{Name: "Users", ShareFolder: `C:\Users`, GuestFolder: "/c/Users"}},
+		{`code=D:\code:/code:ro`, Share{Name: "code", ShareFolder: `D:\code`, GuestFolder: "/code", ReadOnly: true}},
+		{"data=/data:/data:rw", Share{Name: "data", ShareFolder: "/data", GuestFolder: "/data"}},
+	}
+
+	for _, test := range tests {
+		share, err := parseShare(test.value)
+
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.share, share, test.value)
+	}
+}
+
+func TestParseShareInvalid(t *testing.T) {
+	for _, value := range []string{"/home/me/src:/src", "=/src:/src", `src=C:\src`, "src=/src:src"} {
+		_, err := parseShare(value)
+
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseSharesDuplicateName(t *testing.T) {
+	_, err := parseShares([]string{"src=/a:/a", "src=/b:/b"})
+
+	assert.Error(t, err)
+}
+
+func TestParseShareFolder(t *testing.T) {
+	name, folder, err := parseShareFolder(`Users:C:\Users`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Users", name)
+	assert.Equal(t, `C:\Users`, folder)
+
+	for _, value := range []string{"/home/me", ":/home/me", "home:"} {
+		_, _, err := parseShareFolder(value)
+
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadDriverMigratesShare(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+	machineDir := filepath.Join(storePath, "machines", "old")
+	assert.NoError(t, os.MkdirAll(machineDir, 0755))
+	config := `{
+	"DriverName": "vmwareworkstation",
+	"Driver": {
+		"MachineName": "old",
+		"NoShare": false,
+		"ShareName": "Users",
+		"ShareFolder": "C:\\Users\\",
+		"GuestFolder": "/Users",
+		"GuestCompatLink": "/c/Users"
+	}
+}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(machineDir, "config.json"), []byte(config), 0644))
+
+	driver, err := LoadDriver(storePath, "old")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Share{{Name: "Users", ShareFolder: `C:\Users\`, GuestFolder: "/Users"}}, driver.Shares)
+	assert.Equal(t, "/c/Users", driver.GuestCompatLink)
+	assert.Equal(t, shareDriverHGFS, driver.ShareDriver)
+}
+
+func TestMountCommandQuoting(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	command := driver.mountCommand(Share{Name: "src", GuestFolder: "/home/me/my $src"})
+
+	assert.Contains(t, command, `grep -qF ' /home/me/my\040$src ' /proc/mounts || `)
+	assert.Contains(t, command, `sudo mkdir -p '/home/me/my $src'`)
+	assert.Contains(t, command, `'.host:/src' '/home/me/my $src'`)
+}
+
+func TestMountOptions(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	fuse, kernel := driver.mountOptions(Share{Name: "src"})
+
+	assert.Equal(t, "allow_other", fuse)
+	assert.Equal(t, "", kernel)
+
+	driver.ShareUID, driver.ShareGID, driver.ShareUmask = 1000, 50, "022"
+	driver.ShareMountOptions = "allow_other,max_write=1048576"
+	fuse, kernel = driver.mountOptions(Share{Name: "src", ReadOnly: true})
+
+	assert.Equal(t, "allow_other,max_write=1048576,ro,uid=1000,gid=50,umask=022", fuse)
+	assert.Equal(t, "ro,uid=1000,gid=50,fmask=022,dmask=022", kernel)
+}
+
+func TestSystemdEscapePath(t *testing.T) {
+	assert.Equal(t, "Users", systemdEscapePath("/Users"))
+	assert.Equal(t, "c-Users", systemdEscapePath("/c/Users/"))
+	assert.Equal(t, `home-me-my\x2dsrc`, systemdEscapePath("/home/me/my-src"))
+	assert.Equal(t, "-", systemdEscapePath("/"))
+}
+
+func TestMountUnit(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	unit, content := driver.mountUnit(Share{Name: "src", GuestFolder: "/src", ReadOnly: true})
+
+	assert.Equal(t, "src.mount", unit)
+	assert.Contains(t, content, "What=.host:/src\n")
+	assert.Contains(t, content, "Where=/src\n")
+	assert.Contains(t, content, "Options=allow_other,ro\n")
+}
+
+func TestGuestError(t *testing.T) {
+	err := errors.New("exit status 255")
+
+	assert.Contains(t, guestError("Error: Invalid user name or password for the guest OS\r\n", err).Error(), ErrGuestCredentials.Error())
+	assert.Contains(t, guestError("Error: The VMware Tools are not running in the virtual machine\r\n", err).Error(), ErrToolsNotRunning.Error())
+	assert.Equal(t, "exit status 255: Error: Unknown error", guestError("Error: Unknown error\r\n", err).Error())
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnutils"
+	"github.com/docker/machine/libmachine/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// setupSSHKey copies the configured private key to the machine dir, or
+// generates a new one when none is configured.
+func (d *Driver) setupSSHKey() error {
+	if d.SSHKey == "" {
+		log.Infof("Creating SSH key...")
+		return ssh.GenerateSSHKey(d.GetSSHKeyPath())
+	}
+
+	log.Infof("Importing SSH key %s...", d.SSHKey)
+	key, err := ioutil.ReadFile(d.SSHKey)
+	if err != nil {
+		return err
+	}
+	signer, err := cryptossh.ParsePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Unable to use SSH key %s: %s, passphrase protected keys are not supported", d.SSHKey, err)
+	}
+
+	if err := mcnutils.CopyFile(d.SSHKey, d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	// Derive the public key so a missing or stale .pub file does not matter
+	return ioutil.WriteFile(d.publicSSHKeyPath(), cryptossh.MarshalAuthorizedKey(signer.PublicKey()), 0600)
+}
+
+// authorizedKeys returns the authorized_keys content for the guest, the
+// machine public key followed by the extra authorized keys.
+func (d *Driver) authorizedKeys() ([]byte, error) {
+	pubKey, err := ioutil.ReadFile(d.publicSSHKeyPath())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{strings.TrimSpace(string(pubKey))}
+	seen := map[string]bool{keys[0]: true}
+	for _, path := range d.AuthorizedKeys {
+		extra, err := readAuthorizedKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range extra {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return []byte(strings.Join(keys, "\n") + "\n"), nil
+}
+
+// readAuthorizedKeys returns the public keys of an authorized_keys file, or
+// of every *.pub file of a directory.
+func readAuthorizedKeys(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read authorized keys: %s", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.pub")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var keys []string
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range bytes.Split(content, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			if _, _, _, _, err := cryptossh.ParseAuthorizedKey(line); err != nil {
+				return nil, fmt.Errorf("Invalid authorized key in %s line %d: %s", file, i+1, err)
+			}
+			keys = append(keys, string(line))
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,78 @@
+package vmwareworkstation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKey1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHBvd2VyZWQgYnkgdGVzdCBrZXkgb25lLi4uLi4uLi4u alice@laptop"
+	testKey2 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHBvd2VyZWQgYnkgdGVzdCBrZXkgdHdvLi4uLi4uLi4u ci@agent"
+)
+
+func TestSetupSSHKeyImportsKey(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	ownKey := filepath.Join(storePath, "id_rsa")
+	assert.NoError(t, ssh.GenerateSSHKey(ownKey))
+	// A stale public key must not end up in the guest
+	assert.NoError(t, ioutil.WriteFile(ownKey+".pub", []byte(testKey1), 0644))
+
+	driver := NewDriver("default", storePath).(*Driver)
+	driver.SSHKey = ownKey
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+
+	assert.NoError(t, driver.setupSSHKey())
+
+	key, err := ioutil.ReadFile(driver.GetSSHKeyPath())
+	assert.NoError(t, err)
+	ownContent, _ := ioutil.ReadFile(ownKey)
+	assert.Equal(t, ownContent, key)
+	pubKey, err := ioutil.ReadFile(driver.publicSSHKeyPath())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(pubKey), "ssh-rsa "))
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	driver := NewDriver("default", storePath).(*Driver)
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+	assert.NoError(t, ioutil.WriteFile(driver.publicSSHKeyPath(), []byte("ssh-rsa AAAAB3NzaC1yc2E machine\n"), 0644))
+
+	teamDir := filepath.Join(storePath, "team")
+	assert.NoError(t, os.Mkdir(teamDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(teamDir, "alice.pub"), []byte(testKey1+"\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(teamDir, "README"), []byte("not a key"), 0644))
+	ciKeys := filepath.Join(storePath, "ci_keys")
+	assert.NoError(t, ioutil.WriteFile(ciKeys, []byte("# CI agents\n\n"+testKey2+"\n"+testKey1+"\n"), 0644))
+	driver.AuthorizedKeys = []string{teamDir, ciKeys}
+
+	keys, err := driver.authorizedKeys()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-rsa AAAAB3NzaC1yc2E machine\n"+testKey1+"\n"+testKey2+"\n", string(keys))
+}
+
+func TestReadAuthorizedKeysInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(testKey1 + "\nnot a key\n")
+	file.Close()
+
+	_, err = readAuthorizedKeys(file.Name())
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
@@ -0,0 +1,165 @@
+package vmwareworkstation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process stand-in for the SSH daemon of the guest,
+// it records the commands it is asked to run and their input.
+type testSSHServer struct {
+	listener net.Listener
+	config   *cryptossh.ServerConfig
+	hostKey  cryptossh.Signer
+
+	// handler returns the output and the exit status of a command, every
+	// command succeeds without output when it is nil.
+	handler func(command string) (string, uint32)
+
+	mu            sync.Mutex
+	commands      []string
+	stdins        []string
+	authorizedKey cryptossh.PublicKey
+}
+
+func newTestSSHServer(t *testing.T, user, password string) *testSSHServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := cryptossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSSHServer{listener: listener, hostKey: signer}
+	s.config = &cryptossh.ServerConfig{
+		PasswordCallback: func(c cryptossh.ConnMetadata, pass []byte) (*cryptossh.Permissions, error) {
+			if c.User() == user && password != "" && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+		PublicKeyCallback: func(c cryptossh.ConnMetadata, key cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if c.User() == user && s.authorizedKey != nil && bytes.Equal(key.Marshal(), s.authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key rejected for %s", c.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	go s.serve()
+	return s
+}
+
+// authorize accepts the public key in authorized_keys format.
+func (s *testSSHServer) authorize(t *testing.T, authorizedKey []byte) {
+	key, _, _, _, err := cryptossh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.authorizedKey = key
+	s.mu.Unlock()
+}
+
+func (s *testSSHServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testSSHServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testSSHServer) Stdins() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.stdins...)
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn) {
+	_, chans, reqs, err := cryptossh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go cryptossh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(cryptossh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *testSSHServer) handleSession(channel cryptossh.Channel, requests <-chan *cryptossh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := cryptossh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		// The client closes stdin once it has sent its input
+		stdin, _ := ioutil.ReadAll(channel)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, payload.Command)
+		s.stdins = append(s.stdins, string(stdin))
+		s.mu.Unlock()
+
+		var output string
+		var status uint32
+		if s.handler != nil {
+			output, status = s.handler(payload.Command)
+		}
+		channel.Write([]byte(output))
+		channel.SendRequest("exit-status", false, cryptossh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
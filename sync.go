@@ -0,0 +1,242 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	shareDriverRsync   = "rsync"
+	syncStatusFilename = "sync-status.json"
+
+	// sshKnownHostsFilename holds the pinned host key of the guest in the
+	// OpenSSH known_hosts format for the ssh command rsync runs.
+	sshKnownHostsFilename = "ssh_known_hosts"
+)
+
+// States of the rsync share driver.
+const (
+	SyncStateSyncing  = "syncing"
+	SyncStateWatching = "watching"
+	SyncStateStopped  = "stopped"
+	SyncStateError    = "error"
+)
+
+// SyncStatus is the state of the rsync share driver. It is saved in the
+// machine dir so it can be checked while the sync command runs.
+type SyncStatus struct {
+	State     string
+	Updated   time.Time
+	LastError string
+	Shares    []ShareSyncStatus
+}
+
+// ShareSyncStatus is the state of a single share of the rsync share driver.
+type ShareSyncStatus struct {
+	Name        string
+	ShareFolder string
+	GuestFolder string
+	LastSync    time.Time
+	// Changes is the number of host changes the last sync copied, it is -1
+	// after a full sync.
+	Changes int
+}
+
+// Syncer keeps native guest directories in sync with the shared folders
+// using rsync over SSH. It is much faster than VMware shared folders for
+// builds, but changes made in the guest are overwritten.
+type Syncer struct {
+	Driver  *Driver
+	Watcher *Watcher
+
+	status SyncStatus
+}
+
+// NewSyncer returns a syncer for the shares of the driver.
+func NewSyncer(d *Driver) *Syncer {
+	w := NewWatcher(d)
+	w.Ignore = d.syncIgnore()
+
+	s := &Syncer{Driver: d, Watcher: w}
+	for _, share := range d.Shares {
+		s.status.Shares = append(s.status.Shares, ShareSyncStatus{
+			Name:        share.Name,
+			ShareFolder: share.ShareFolder,
+			GuestFolder: share.GuestFolder,
+		})
+	}
+	return s
+}
+
+// SyncAll copies every share to the guest.
+func (s *Syncer) SyncAll() error {
+	// The guest folders of the other share drivers are mounts of the host
+	// folders, rsync --delete would copy them onto themselves
+	if s.Driver.ShareDriver != shareDriverRsync {
+		return fmt.Errorf("%s uses the %s share driver, only machines created with --vmwareworkstation-share-driver rsync can be synced", s.Driver.MachineName, s.Driver.ShareDriver)
+	}
+	if err := s.prepareGuest(); err != nil {
+		s.setState(SyncStateError, err)
+		return err
+	}
+
+	for i := range s.Driver.Shares {
+		if err := s.syncShare(i, -1); err != nil {
+			s.setState(SyncStateError, err)
+			return err
+		}
+	}
+	s.setState(SyncStateStopped, nil)
+	return nil
+}
+
+// Run copies every share to the guest, then copies the shares again every
+// time they change on the host until stop is closed.
+func (s *Syncer) Run(stop <-chan struct{}) error {
+	if err := s.SyncAll(); err != nil {
+		return err
+	}
+
+	s.setState(SyncStateWatching, nil)
+	err := s.Watcher.watchShares(stop, func(changes []map[string]time.Time) error {
+		var syncErr error
+		for i, files := range changes {
+			if len(files) == 0 {
+				continue
+			}
+			// A failed sync is retried with the next change
+			if err := s.syncShare(i, len(files)); err != nil {
+				log.Warnf("%s", err)
+				syncErr = err
+			}
+		}
+		if syncErr != nil {
+			s.setState(SyncStateError, syncErr)
+		} else {
+			s.setState(SyncStateWatching, nil)
+		}
+		return nil
+	})
+
+	s.setState(SyncStateStopped, err)
+	return err
+}
+
+// prepareGuest installs rsync in the guest and creates the guest
+// directories owned by the SSH user.
+func (s *Syncer) prepareGuest() error {
+	d := s.Driver
+	if err := executeSSHCommand(installGuestPackage("rsync", "rsync"), d); err != nil {
+		return fmt.Errorf("Unable to install rsync in the guest: %s", err)
+	}
+	for _, share := range d.Shares {
+		command := fmt.Sprintf("sudo mkdir -p %s && sudo chown %s %s", shQuote(share.GuestFolder), d.SSHUser, shQuote(share.GuestFolder))
+		if err := executeSSHCommand(command, d); err != nil {
+			return fmt.Errorf("Unable to create %s in the guest: %s", share.GuestFolder, err)
+		}
+	}
+	// The commands above pinned the host key of the guest
+	return d.writeKnownHosts()
+}
+
+// writeKnownHosts writes the pinned host key of the guest for the machine
+// name used as the host key alias by rsyncArgs.
+func (d *Driver) writeKnownHosts() error {
+	content, err := ioutil.ReadFile(d.ResolveStorePath(knownHostFilename))
+	if err != nil {
+		return fmt.Errorf("Unable to read the pinned SSH host key: %s", err)
+	}
+	key, _, _, _, err := cryptossh.ParseAuthorizedKey(content)
+	if err != nil {
+		return fmt.Errorf("Unable to read the pinned SSH host key: %s", err)
+	}
+
+	line := d.MachineName + " " + string(cryptossh.MarshalAuthorizedKey(key))
+	return ioutil.WriteFile(d.ResolveStorePath(sshKnownHostsFilename), []byte(line), 0600)
+}
+
+func (s *Syncer) syncShare(i int, changes int) error {
+	share := s.Driver.Shares[i]
+	log.Infof("Syncing %s to %s...", share.ShareFolder, share.GuestFolder)
+
+	s.setState(SyncStateSyncing, nil)
+	if out, err := hostCommand("rsync", s.Driver.rsyncArgs(share)...); err != nil {
+		return fmt.Errorf("Unable to sync %s: %s: %s", share.ShareFolder, err, strings.TrimSpace(out))
+	}
+
+	s.status.Shares[i].LastSync = time.Now()
+	s.status.Shares[i].Changes = changes
+	return nil
+}
+
+func (s *Syncer) setState(state string, err error) {
+	s.status.State = state
+	s.status.Updated = time.Now()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+
+	status, _ := json.MarshalIndent(s.status, "", "  ")
+	if err := ioutil.WriteFile(s.Driver.ResolveStorePath(syncStatusFilename), status, 0644); err != nil {
+		log.Debugf("Unable to save the sync status: %s", err)
+	}
+}
+
+// ReadSyncStatus returns the last state saved by the rsync share driver.
+func ReadSyncStatus(d *Driver) (*SyncStatus, error) {
+	content, err := ioutil.ReadFile(d.ResolveStorePath(syncStatusFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &SyncStatus{}
+	if err := json.Unmarshal(content, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (d *Driver) syncIgnore() []string {
+	return append(append([]string(nil), defaultWatchIgnore...), d.SyncIgnore...)
+}
+
+// rsyncArgs returns the arguments of the rsync command copying the share to
+// the guest with the machine SSH key, checking the pinned host key.
+func (d *Driver) rsyncArgs(share Share) []string {
+	args := []string{
+		"--archive",
+		"--delete",
+		"--rsh", fmt.Sprintf(
+			`ssh -i "%s" -p %d -o StrictHostKeyChecking=yes -o UserKnownHostsFile="%s" -o HostKeyAlias=%s`,
+			rsyncPath(d.GetSSHKeyPath()), d.SSHPort, rsyncPath(d.ResolveStorePath(sshKnownHostsFilename)), d.MachineName,
+		),
+	}
+	for _, pattern := range d.syncIgnore() {
+		args = append(args, "--exclude", pattern)
+	}
+	return append(args,
+		strings.TrimSuffix(rsyncPath(share.ShareFolder), "/")+"/",
+		fmt.Sprintf("%s@%s:%s/", d.SSHUser, d.IPAddress, strings.TrimSuffix(share.GuestFolder, "/")),
+	)
+}
+
+// rsyncPath converts a Windows path to the cygwin form rsync builds for
+// Windows expect, C:\Users becomes /cygdrive/c/Users.
+func rsyncPath(p string) string {
+	p = strings.Replace(p, `\`, "/", -1)
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/cygdrive/" + strings.ToLower(p[:1]) + p[2:]
+	}
+	return p
+}
@@ -0,0 +1,72 @@
+package vmwareworkstation
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestRsyncPath(t *testing.T) {
+	assert.Equal(t, "/cygdrive/c/Users/me/src", rsyncPath(`C:\Users\me\src`))
+	assert.Equal(t, "/home/me/src", rsyncPath("/home/me/src"))
+}
+
+func TestRsyncArgs(t *testing.T) {
+	driver := NewDriver("default", "/store").(*Driver)
+	driver.IPAddress = "192.168.38.128"
+	driver.SSHPort = 22
+	driver.SSHKeyPath = `C:\machine\id_rsa`
+	driver.SyncIgnore = []string{"node_modules"}
+
+	args := driver.rsyncArgs(Share{Name: "src", ShareFolder: `C:\src\`, GuestFolder: "/src"})
+
+	assert.Equal(t, []string{
+		"--archive",
+		"--delete",
+		"--rsh", `ssh -i "/cygdrive/c/machine/id_rsa" -p 22 -o StrictHostKeyChecking=yes -o UserKnownHostsFile="/store/machines/default/ssh_known_hosts" -o HostKeyAlias=default`,
+		"--exclude", ".git",
+		"--exclude", "node_modules",
+		"/cygdrive/c/src/",
+		"docker@192.168.38.128:/src/",
+	}, args)
+}
+
+func TestSyncAll(t *testing.T) {
+	driver, server, hostCommands, cleanup := newTestShareDriver(t, shareDriverRsync)
+	defer cleanup()
+
+	err := NewSyncer(driver).SyncAll()
+
+	assert.NoError(t, err)
+	assert.Len(t, *hostCommands, 1)
+	assert.True(t, strings.HasPrefix((*hostCommands)[0], "rsync --archive --delete"))
+	assert.Contains(t, strings.Join(server.Commands(), "\n"), "sudo mkdir -p '/src' && sudo chown docker '/src'")
+
+	knownHosts, err := ioutil.ReadFile(driver.ResolveStorePath(sshKnownHostsFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, "default "+string(cryptossh.MarshalAuthorizedKey(server.hostKey.PublicKey())), string(knownHosts))
+
+	status, err := ReadSyncStatus(driver)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, SyncStateStopped, status.State)
+	assert.Len(t, status.Shares, 1)
+	assert.False(t, status.Shares[0].LastSync.IsZero())
+	assert.Equal(t, -1, status.Shares[0].Changes)
+}
+
+func TestSyncAllOtherShareDriver(t *testing.T) {
+	driver, server, hostCommands, cleanup := newTestShareDriver(t, shareDriverHGFS)
+	defer cleanup()
+
+	err := NewSyncer(driver).SyncAll()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "uses the hgfs share driver")
+	assert.Empty(t, *hostCommands)
+	assert.Empty(t, server.Commands())
+}
@@ -58,6 +58,21 @@ func workstationDhcpLeasesPathRegistry() (s string, err error) {
 	return normalizePath(s), nil
 }
 
+// This reads the version of the installed VMware Workstation from the
+// Windows registry.
+func workstationVersion() (string, error) {
+	keys := []string{
+		`SOFTWARE\WOW6432Node\VMware, Inc.\VMware Workstation`,
+		`SOFTWARE\VMware, Inc.\VMware Workstation`,
+	}
+	for _, key := range keys {
+		if s, err := readRegString(syscall.HKEY_LOCAL_MACHINE, key, "ProductVersion"); err == nil {
+			return s, nil
+		}
+	}
+	return "", errors.New("VMware Workstation version not found in the registry")
+}
+
 func workstationDhcpLeasesPath() string {
 	path, err := workstationDhcpLeasesPathRegistry()
 	if err != nil {
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const vmwareLogFilename = "vmware.log"
+
+var (
+	ErrHyperVConflict  = errors.New("Hyper-V or Virtualization Based Security is enabled on the host")
+	ErrVTxDisabled     = errors.New("hardware virtualization is not available on the host")
+	ErrNotEnoughMemory = errors.New("not enough host memory to power on the VM")
+	ErrFileLocked      = errors.New("a file of the VM is locked by another process")
+)
+
+// VMwareLogError is a boot failure explained by the vmware.log of the
+// machine, Err is the known failure it matched.
+type VMwareLogError struct {
+	Err   error
+	Cause error
+	Hint  string
+	Path  string
+	Line  string
+}
+
+func (e *VMwareLogError) Error() string {
+	return fmt.Sprintf("%s: %s\n%s\n%s: %s", e.Cause, e.Err, e.Hint, e.Path, e.Line)
+}
+
+// Unwrap lets errors.Is match the known failure.
+func (e *VMwareLogError) Unwrap() error {
+	return e.Err
+}
+
+// vmwareLogSignature is a known boot failure and how vmware.log reports it.
+type vmwareLogSignature struct {
+	Pattern *regexp.Regexp
+	Err     error
+	Hint    string
+}
+
+// vmwareLogSignatures are matched in order, Hyper-V first as it also hides
+// VT-x from VMware Workstation.
+var vmwareLogSignatures = []vmwareLogSignature{
+	{
+		Pattern: regexp.MustCompile(`(?i)(Device/Credential Guard|Hyper-V) (is|are) not compatible`),
+		Err:     ErrHyperVConflict,
+		Hint:    "Disable Hyper-V and Memory Integrity, or upgrade to VMware Workstation 15.5.5 or newer to run on top of Hyper-V",
+	},
+	{
+		Pattern: regexp.MustCompile(`(?i)(Intel VT-x|AMD-V|AMD-v) (is|has been) disabled|does not support ("?Intel VT-x"?|"?AMD-V"?)|Virtualized (Intel VT-x/EPT|AMD-V/RVI) is not supported`),
+		Err:     ErrVTxDisabled,
+		Hint:    "Enable Intel VT-x or AMD-V in the BIOS/UEFI setup of the host, or disable --vmwareworkstation-nested-virtualization",
+	},
+	{
+		Pattern: regexp.MustCompile(`(?i)not enough physical memory|insufficient (physical )?memory|could not allocate (the )?(guest|main) memory|failed to lock the main memory file`),
+		Err:     ErrNotEnoughMemory,
+		Hint:    "Lower --vmwareworkstation-memory-size or free memory on the host",
+	},
+	{
+		Pattern: regexp.MustCompile(`(?i)failed to lock the file|file is (already )?in use|being used by another (process|virtual machine)`),
+		Err:     ErrFileLocked,
+		Hint:    "Make sure no other VMware process uses the VM, then remove the stale .lck directories of the machine dir",
+	},
+}
+
+// matchVMwareLog returns the first known failure signature found in a
+// vmware.log and the line it matched, nil when none matched.
+func matchVMwareLog(r io.Reader) (*vmwareLogSignature, string) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	for i := range vmwareLogSignatures {
+		for _, line := range lines {
+			if vmwareLogSignatures[i].Pattern.MatchString(line) {
+				return &vmwareLogSignatures[i], strings.TrimSpace(line)
+			}
+		}
+	}
+	return nil, ""
+}
+
+// withVMwareLog explains a failed boot with the vmware.log of the machine when
+// it shows a known failure.
+func (d *Driver) withVMwareLog(err error) error {
+	path := d.ResolveStorePath(vmwareLogFilename)
+	f, openErr := os.Open(path)
+	if openErr != nil {
+		log.Debugf("Unable to read %s: %s", path, openErr)
+		return err
+	}
+	defer f.Close()
+
+	signature, line := matchVMwareLog(f)
+	if signature == nil {
+		return err
+	}
+	return &VMwareLogError{Err: signature.Err, Cause: err, Hint: signature.Hint, Path: path, Line: line}
+}
@@ -0,0 +1,63 @@
+package vmwareworkstation
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchVMwareLog(t *testing.T) {
+	tests := []struct {
+		fixture string
+		err     error
+		line    string
+	}{
+		{"hyperv.log", ErrHyperVConflict, "Device/Credential Guard are not compatible"},
+		{"vtx-disabled.log", ErrVTxDisabled, "but Intel VT-x is disabled"},
+		{"memory.log", ErrNotEnoughMemory, "Not enough physical memory is available"},
+		{"locked.log", ErrFileLocked, "Failed to lock the file (40003)"},
+		{"ok.log", nil, ""},
+	}
+
+	for _, test := range tests {
+		f, err := os.Open(filepath.Join("testdata", "vmwarelog", test.fixture))
+		assert.NoError(t, err)
+
+		signature, line := matchVMwareLog(f)
+		f.Close()
+
+		if test.err == nil {
+			assert.Nil(t, signature, test.fixture)
+			continue
+		}
+		if assert.NotNil(t, signature, test.fixture) {
+			assert.Equal(t, test.err, signature.Err, test.fixture)
+			assert.Contains(t, line, test.line, test.fixture)
+		}
+	}
+}
+
+func TestWithVMwareLog(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+	startErr := errors.New("Unable to start default: Error: The operation was canceled")
+
+	assert.Equal(t, startErr, driver.withVMwareLog(startErr))
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "vmwarelog", "vtx-disabled.log"))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(driver.ResolveStorePath(vmwareLogFilename), content, 0644))
+
+	err = driver.withVMwareLog(startErr)
+	assert.Contains(t, err.Error(), "The operation was canceled: "+ErrVTxDisabled.Error())
+	assert.Contains(t, err.Error(), "Enable Intel VT-x or AMD-V")
+	if logErr, ok := err.(*VMwareLogError); assert.True(t, ok) {
+		assert.Equal(t, ErrVTxDisabled, logErr.Err)
+		assert.Equal(t, startErr, logErr.Cause)
+	}
+}
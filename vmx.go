@@ -39,7 +39,7 @@ sound.pciSlotNumber = "33"
 vmci0.pciSlotNumber = "35"
 sata0.pciSlotNumber = "36"
 floppy0.present = "FALSE"
-guestOS = "other3xlinux-64"
+guestOS = "{{.GuestOS}}"
 hpet0.present = "TRUE"
 sata0.present = "TRUE"
 sata0:1.present = "TRUE"
@@ -58,14 +58,43 @@ powerType.powerOn = "soft"
 powerType.reset = "soft"
 powerType.suspend = "soft"
 scsi0.present = "TRUE"
-scsi0.virtualDev = "pvscsi"
+scsi0.virtualDev = "{{ if and (eq .Firmware "efi" "efi-secureboot") (lt .HardwareVersion 13) }}lsisas1068{{ else }}pvscsi{{ end }}"
 scsi0:0.fileName = "{{.MachineName}}.vmdk"
 scsi0:0.present = "TRUE"
 virtualHW.productCompatibility = "hosted"
-virtualHW.version = "10"
+virtualHW.version = "{{.HardwareVersion}}"
 msg.autoanswer = "TRUE"
 uuid.action = "create"
 numvcpus = "{{.CPU}}"
+{{- if .CoresPerSocket }}
+cpuid.coresPerSocket = "{{.CoresPerSocket}}"
+{{- end }}
+{{- if .NestedVirtualization }}
+vhv.enable = "TRUE"
+{{- end }}
+{{- if .PerformanceCounters }}
+vpmc.enable = "TRUE"
+{{- end }}
+{{- if eq .Firmware "efi" "efi-secureboot" }}
+firmware = "efi"
+bios.bootOrder = "cdrom,hdd"
+{{- if eq .Firmware "efi-secureboot" }}
+uefi.secureBoot.enabled = "TRUE"
+{{- end }}
+{{- end }}
+{{- if .SerialLog }}
+serial0.present = "TRUE"
+serial0.fileType = "file"
+serial0.fileName = "serial.log"
+serial0.tryNoRxLoss = "FALSE"
+{{- end }}
+{{- if .VNC }}
+RemoteDisplay.vnc.enabled = "TRUE"
+RemoteDisplay.vnc.port = "{{.VNCPort}}"
+{{- if .VNCPassword }}
+RemoteDisplay.vnc.password = {{quote .VNCPassword}}
+{{- end }}
+{{- end }}
 hgfs.mapRootShare = "FALSE"
 hgfs.linkRootShare = "FALSE"
 `
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+var (
+	vmxKeyRegexp  = regexp.MustCompile(`^[A-Za-z0-9_.:]+$`)
+	vmxLineRegexp = regexp.MustCompile(`^\s*([A-Za-z0-9_.:]+)\s*=`)
+
+	// Keys vmrun manages besides the ones of the vmx template.
+	vmrunManagedVMXPrefixes = []string{"sharedfolder", "ethernet0.generatedaddress", "uuid."}
+)
+
+// parseVMXOption parses a VMX option of the form key=value.
+func parseVMXOption(option string) (string, string, error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid vmx option %q, the format is key=value", option)
+	}
+
+	key, value := strings.TrimSpace(parts[0]), strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	if !vmxKeyRegexp.MatchString(key) {
+		return "", "", fmt.Errorf("invalid vmx option key %q", key)
+	}
+	if strings.ContainsAny(value, "\"\r\n") {
+		return "", "", fmt.Errorf("invalid vmx option value %q, it cannot contain quotes or line breaks", value)
+	}
+	return key, value, nil
+}
+
+// parseVMXOptions parses the VMX options and warns about the ones replacing
+// a setting the driver manages.
+func parseVMXOptions(options []string) (map[string]string, error) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+
+	managed := managedVMXKeys()
+	parsed := map[string]string{}
+	for _, option := range options {
+		key, value, err := parseVMXOption(option)
+		if err != nil {
+			return nil, err
+		}
+		if isManagedVMXKey(managed, key) {
+			log.Warnf("vmx option %s overrides a setting managed by the driver, the machine may not work as expected", key)
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+// managedVMXKeys returns the keys the vmx template sets, lower cased as VMX
+// keys are case insensitive.
+func managedVMXKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, line := range strings.Split(vmx, "\n") {
+		if m := vmxLineRegexp.FindStringSubmatch(line); m != nil {
+			keys[strings.ToLower(m[1])] = true
+		}
+	}
+	return keys
+}
+
+func isManagedVMXKey(managed map[string]bool, key string) bool {
+	key = strings.ToLower(key)
+	if managed[key] {
+		return true
+	}
+	for _, prefix := range vmrunManagedVMXPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeVMX sets the options in the VMX content, replacing the existing keys
+// in place and appending the other ones.
+func mergeVMX(content []byte, options map[string]string) []byte {
+	pending := map[string]string{}
+	for key := range options {
+		pending[strings.ToLower(key)] = key
+	}
+
+	var b bytes.Buffer
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if m := vmxLineRegexp.FindStringSubmatch(line); m != nil {
+			if key, ok := pending[strings.ToLower(m[1])]; ok {
+				fmt.Fprintf(&b, "%s = \"%s\"\n", m[1], options[key])
+				delete(pending, strings.ToLower(m[1]))
+				continue
+			}
+		}
+		b.WriteString(line)
+	}
+
+	keys := make([]string, 0, len(pending))
+	for _, key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 && b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteString("\n")
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = \"%s\"\n", key, options[key])
+	}
+	return b.Bytes()
+}
+
+// applyVMXOptions sets the VMX options in the VMX file of the machine, it
+// must only run while the VM is powered off.
+func (d *Driver) applyVMXOptions() error {
+	if len(d.VMXOptions) == 0 {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(d.vmxPath())
+	if err != nil {
+		return err
+	}
+	merged := mergeVMX(content, d.VMXOptions)
+	if bytes.Equal(merged, content) {
+		return nil
+	}
+
+	log.Debugf("Applying vmx options to %s", d.vmxPath())
+	return ioutil.WriteFile(d.vmxPath(), merged, 0644)
+}
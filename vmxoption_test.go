@@ -0,0 +1,53 @@
+package vmwareworkstation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVMXOptions(t *testing.T) {
+	options, err := parseVMXOptions([]string{"vhv.enable=TRUE", `mainMem.useNamedFile = "FALSE"`, "memsize=4096"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"vhv.enable":           "TRUE",
+		"mainMem.useNamedFile": "FALSE",
+		"memsize":              "4096",
+	}, options)
+}
+
+func TestParseVMXOptionInvalid(t *testing.T) {
+	for _, option := range []string{"vhv.enable", "vhv enable=TRUE", "displayName=a\"b", "annotation=a\nb"} {
+		_, _, err := parseVMXOption(option)
+		assert.Error(t, err, option)
+	}
+}
+
+func TestIsManagedVMXKey(t *testing.T) {
+	managed := managedVMXKeys()
+
+	assert.True(t, isManagedVMXKey(managed, "memsize"))
+	assert.True(t, isManagedVMXKey(managed, "NumVCPUs"))
+	assert.True(t, isManagedVMXKey(managed, "sata0:2.fileName"))
+	assert.True(t, isManagedVMXKey(managed, "sharedFolder0.readAccess"))
+	assert.True(t, isManagedVMXKey(managed, "vhv.enable"))
+	assert.False(t, isManagedVMXKey(managed, "mainMem.useNamedFile"))
+}
+
+func TestMergeVMX(t *testing.T) {
+	content := []byte(".encoding = \"UTF-8\"\nmemsize = \"1024\"\nvhv.enable = \"FALSE\"\n")
+
+	merged := mergeVMX(content, map[string]string{
+		"VHV.enable":              "TRUE",
+		"sched.mem.pshare.enable": "FALSE",
+		"mainMem.useNamedFile":    "FALSE",
+	})
+
+	assert.Equal(t, ".encoding = \"UTF-8\"\n"+
+		"memsize = \"1024\"\n"+
+		"vhv.enable = \"TRUE\"\n"+
+		"mainMem.useNamedFile = \"FALSE\"\n"+
+		"sched.mem.pshare.enable = \"FALSE\"\n", string(merged))
+	assert.Equal(t, string(merged), string(mergeVMX(merged, map[string]string{"vhv.enable": "TRUE"})))
+}
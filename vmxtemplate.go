@@ -0,0 +1,133 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// vmxFuncs are the helper functions of the vmx templates.
+var vmxFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  strings.Join,
+	"add":   func(a, b int) int { return a + b },
+	// quote escapes a value for a VMX string, VMX files have no escapes so
+	// the double quotes are dropped.
+	"quote": func(s string) string { return `"` + strings.Replace(s, `"`, "", -1) + `"` },
+	// default returns the value unless it is empty.
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || fmt.Sprint(value) == "" || fmt.Sprint(value) == "0" {
+			return def
+		}
+		return value
+	},
+	// bool returns the VMX form of a boolean.
+	"bool": func(b bool) string {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	},
+}
+
+// parseVMXTemplate parses the vmx template of the driver, the built-in one
+// unless a template file was configured.
+func (d *Driver) parseVMXTemplate() (*template.Template, error) {
+	text, name := vmx, "vmx"
+	if d.VMXTemplate != "" {
+		content, err := ioutil.ReadFile(d.VMXTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read vmx template: %s", err)
+		}
+		text, name = string(content), filepath.Base(d.VMXTemplate)
+	}
+
+	t, err := template.New(name).Funcs(vmxFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vmx template: %s", err)
+	}
+	return t, nil
+}
+
+// renderVMX returns the VMX file of the machine, with the profile and then
+// the VMX options applied.
+func (d *Driver) renderVMX() ([]byte, error) {
+	t, err := d.parseVMXTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return nil, fmt.Errorf("Unable to render vmx template: %s", err)
+	}
+
+	content := mergeVMX(applyProfile(b.Bytes(), d.Profile), d.VMXOptions)
+	if err := d.validateVMX(content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// validateVMX checks the VMX file attaches what the driver needs to boot and
+// reach the machine.
+func (d *Driver) validateVMX(content []byte) error {
+	settings := parseVMX(content)
+
+	var missing []string
+	if !hasVMXFile(settings, func(name string) bool { return filepath.Base(name) == d.MachineName+".vmdk" }) {
+		missing = append(missing, fmt.Sprintf("a disk using %s.vmdk", d.MachineName))
+	}
+	if !hasVMXFile(settings, func(name string) bool { return name == d.ISO }) {
+		missing = append(missing, fmt.Sprintf("a CD-ROM using %s", d.ISO))
+	}
+	if d.ConfigDriveURL != "" && !hasVMXFile(settings, func(name string) bool { return name == d.ConfigDriveISO }) {
+		missing = append(missing, fmt.Sprintf("a CD-ROM using %s", d.ConfigDriveISO))
+	}
+	if d.SerialLog && !hasVMXFile(settings, func(name string) bool { return filepath.Base(name) == serialLogFilename }) {
+		missing = append(missing, fmt.Sprintf("a serial port using %s", serialLogFilename))
+	}
+	if !strings.EqualFold(settings["ethernet0.present"], "TRUE") {
+		missing = append(missing, `a network adapter with ethernet0.present = "TRUE"`)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("The vmx template is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseVMX returns the settings of a VMX file with lower cased keys.
+func parseVMX(content []byte) map[string]string {
+	settings := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || !vmxLineRegexp.MatchString(line) {
+			continue
+		}
+		settings[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	}
+	return settings
+}
+
+// hasVMXFile tells whether a present device uses a file matching match.
+func hasVMXFile(settings map[string]string, match func(name string) bool) bool {
+	for key, value := range settings {
+		if !strings.HasSuffix(key, ".filename") || !match(value) {
+			continue
+		}
+		device := strings.TrimSuffix(key, ".filename")
+		if strings.EqualFold(settings[device+".present"], "TRUE") {
+			return true
+		}
+	}
+	return false
+}
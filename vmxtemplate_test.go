@@ -0,0 +1,116 @@
+package vmwareworkstation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVMXDriver(t *testing.T, template string) (*Driver, func()) {
+	dir, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+
+	driver := NewDriver("default", dir).(*Driver)
+	driver.ISO = driver.ResolveStorePath(isoFilename)
+	driver.ConfigDriveISO = driver.ResolveStorePath(isoConfigDrive)
+	if template != "" {
+		driver.VMXTemplate = filepath.Join(dir, "custom.vmx.tmpl")
+		assert.NoError(t, ioutil.WriteFile(driver.VMXTemplate, []byte(template), 0644))
+	}
+	return driver, func() { os.RemoveAll(dir) }
+}
+
+func TestRenderVMXBuiltin(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	driver.ConfigDriveURL = "http://example.com/configdrive.iso"
+	driver.VMXOptions = map[string]string{"vhv.enable": "TRUE"}
+
+	content, err := driver.renderVMX()
+
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	assert.Equal(t, "default", settings["displayname"])
+	assert.Equal(t, driver.ConfigDriveISO, settings["sata0:2.filename"])
+	assert.Equal(t, "TRUE", settings["vhv.enable"])
+}
+
+func TestRenderVMXTemplate(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, `displayName = {{quote .MachineName}}
+firmware = "efi"
+ethernet0.present = "TRUE"
+ethernet0.virtualDev = "e1000e"
+nvme0.present = "TRUE"
+nvme0:0.present = "TRUE"
+nvme0:0.fileName = "{{.MachineName}}.vmdk"
+sata0.present = "TRUE"
+sata0:0.present = "TRUE"
+sata0:0.fileName = "{{.ISO}}"
+sata0:0.deviceType = "cdrom-image"
+memsize = "{{add .Memory 512}}"
+numvcpus = "{{default 2 .CPU}}"
+guestOS = "{{lower "OTHER3XLINUX-64"}}"
+`)
+	defer cleanup()
+
+	content, err := driver.renderVMX()
+
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	assert.Equal(t, "efi", settings["firmware"])
+	assert.Equal(t, "1536", settings["memsize"])
+	assert.Equal(t, "2", settings["numvcpus"])
+	assert.Equal(t, "other3xlinux-64", settings["guestos"])
+}
+
+func TestSetConfigFromFlagsRendersVMXTemplate(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, `guestOS = "{{.GuestOS}}"
+{{if eq .GuestOS "ubuntu-64"}}ethernet0.present = "TRUE"{{end}}
+sata0:0.present = "TRUE"
+sata0:0.fileName = "{{.MachineName}}.vmdk"
+sata0:1.present = "TRUE"
+sata0:1.fileName = "{{.ISO}}"
+`)
+	defer cleanup()
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwareworkstation-vmx-template": driver.VMXTemplate,
+			"vmwareworkstation-guest-os":     "ubuntu-64",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(checkFlags))
+	assert.Equal(t, "ubuntu-64", driver.GuestOS)
+}
+
+func TestRenderVMXTemplateMissingKeys(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, `displayName = "{{.MachineName}}"
+scsi0:0.present = "FALSE"
+scsi0:0.fileName = "{{.MachineName}}.vmdk"
+`)
+	defer cleanup()
+
+	_, err := driver.renderVMX()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a disk using default.vmdk")
+	assert.Contains(t, err.Error(), "a CD-ROM using "+driver.ISO)
+	assert.Contains(t, err.Error(), "a network adapter")
+}
+
+func TestRenderVMXTemplateInvalid(t *testing.T) {
+	for _, template := range []string{`memsize = "{{.Memory"`, `memsize = "{{.NoSuchField}}"`} {
+		driver, cleanup := newTestVMXDriver(t, template)
+
+		_, err := driver.renderVMX()
+
+		assert.Error(t, err, template)
+		cleanup()
+	}
+}
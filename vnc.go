@@ -0,0 +1,115 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// VNC ports picked when none is configured, 5900 is left to a VNC
+	// server of the host.
+	vncPortMin = 5901
+	vncPortMax = 5999
+
+	// vncPasswordMaxLength is the longest password the VNC authentication
+	// uses, longer ones are silently truncated by the clients.
+	vncPasswordMaxLength = 8
+)
+
+var ErrVNCDisabled = errors.New("VNC is not enabled, create the machine with --vmwareworkstation-vnc")
+
+// checkVNC validates the VNC flags.
+func (d *Driver) checkVNC() error {
+	if !d.VNC {
+		if d.VNCPort != 0 || d.VNCPassword != "" {
+			return fmt.Errorf("the VNC port and password need --vmwareworkstation-vnc")
+		}
+		return nil
+	}
+
+	if d.VNCPort < 0 || d.VNCPort > 65535 {
+		return fmt.Errorf("invalid VNC port %d", d.VNCPort)
+	}
+	// Anyone reaching the host could take over an open console
+	if d.VNCPassword == "" {
+		return fmt.Errorf("the VNC console needs --vmwareworkstation-vnc-password")
+	}
+	if len(d.VNCPassword) > vncPasswordMaxLength {
+		return fmt.Errorf("the VNC password is limited to %d characters", vncPasswordMaxLength)
+	}
+	if strings.ContainsAny(d.VNCPassword, "\"\r\n") {
+		return fmt.Errorf("the VNC password cannot contain quotes or line breaks")
+	}
+	return nil
+}
+
+// setupVNC picks a free VNC port when VNC is enabled without one.
+func (d *Driver) setupVNC() error {
+	if !d.VNC || d.VNCPort != 0 {
+		return nil
+	}
+
+	used := d.usedVNCPorts()
+	for port := vncPortMin; port <= vncPortMax; port++ {
+		if used[port] || !portFree(port) {
+			continue
+		}
+		log.Infof("Using VNC port %d", port)
+		d.VNCPort = port
+		return nil
+	}
+	return fmt.Errorf("No free VNC port between %d and %d, use --vmwareworkstation-vnc-port", vncPortMin, vncPortMax)
+}
+
+// usedVNCPorts returns the VNC ports of the other machines of the store, they
+// are only listened on while the machines run.
+func (d *Driver) usedVNCPorts() map[int]bool {
+	used := map[int]bool{}
+	paths, _ := filepath.Glob(filepath.Join(d.StorePath, "machines", "*", "*.vmx"))
+	for _, path := range paths {
+		if path == d.vmxPath() {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		settings := parseVMX(content)
+		if !strings.EqualFold(settings["remotedisplay.vnc.enabled"], "TRUE") {
+			continue
+		}
+		if port, err := strconv.Atoi(settings["remotedisplay.vnc.port"]); err == nil {
+			used[port] = true
+		}
+	}
+	return used
+}
+
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// VNCEndpoint returns the address of the VNC console of the machine, VMware
+// listens on every interface of the host.
+func (d *Driver) VNCEndpoint() (string, error) {
+	if !d.VNC {
+		return "", ErrVNCDisabled
+	}
+	return fmt.Sprintf("vnc://127.0.0.1:%d", d.VNCPort), nil
+}
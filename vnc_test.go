@@ -0,0 +1,69 @@
+package vmwareworkstation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVNC(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.VNCPort = 5901
+	assert.Contains(t, driver.checkVNC().Error(), "need --vmwareworkstation-vnc")
+
+	driver.VNC = true
+	assert.Contains(t, driver.checkVNC().Error(), "needs --vmwareworkstation-vnc-password")
+
+	driver.VNCPassword = "secret"
+	assert.NoError(t, driver.checkVNC())
+
+	driver.VNCPassword = "toolongpassword"
+	assert.Contains(t, driver.checkVNC().Error(), "limited to 8 characters")
+
+	driver.VNCPassword, driver.VNCPort = "secret", 70000
+	assert.Contains(t, driver.checkVNC().Error(), "invalid VNC port 70000")
+}
+
+func TestSetupVNC(t *testing.T) {
+	driver, cleanup := newTestVMXDriver(t, "")
+	defer cleanup()
+	driver.VNC = true
+
+	// Another machine of the store claims the first port
+	other := filepath.Join(driver.StorePath, "machines", "other")
+	assert.NoError(t, os.MkdirAll(other, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(other, "other.vmx"), []byte(fmt.Sprintf("RemoteDisplay.vnc.enabled = \"TRUE\"\nRemoteDisplay.vnc.port = \"%d\"\n", vncPortMin)), 0644))
+	// And a process of the host listens on the second one
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", vncPortMin+1))
+	if err == nil {
+		defer l.Close()
+	}
+
+	assert.NoError(t, driver.setupVNC())
+	assert.True(t, driver.VNCPort > vncPortMin+1 && driver.VNCPort <= vncPortMax)
+
+	driver.VNCPassword = "secret"
+	content, err := driver.renderVMX()
+	assert.NoError(t, err)
+	settings := parseVMX(content)
+	assert.Equal(t, "TRUE", settings["remotedisplay.vnc.enabled"])
+	assert.Equal(t, fmt.Sprintf("%d", driver.VNCPort), settings["remotedisplay.vnc.port"])
+	assert.Equal(t, "secret", settings["remotedisplay.vnc.password"])
+
+	endpoint, err := driver.VNCEndpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("vnc://127.0.0.1:%d", driver.VNCPort), endpoint)
+}
+
+func TestVNCEndpointDisabled(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	_, err := driver.VNCEndpoint()
+
+	assert.Equal(t, ErrVNCDisabled, err)
+}
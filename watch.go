@@ -0,0 +1,326 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// defaultWatchInterval is the interval between two scans when the
+	// shared folders cannot be watched for notifications.
+	defaultWatchInterval = 2 * time.Second
+	defaultWatchDebounce = time.Second
+
+	// touchAgent is the guest side of the watcher. It reads "mtime path"
+	// lines and touches every path back to the mtime it has on the host, so
+	// inotify fires in the guest without the file changing again.
+	touchAgent = `while read -r mtime path; do touch -c -m -d "@$mtime" "$path" 2>/dev/null; done`
+)
+
+var defaultWatchIgnore = []string{".git"}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher forwards the changes made on the host in the shared folders to
+// the guest. Shared folder file systems do not carry inotify events, so watch
+// based tools running in containers never see edits made on the host.
+type Watcher struct {
+	Driver   *Driver
+	Interval time.Duration
+	Debounce time.Duration
+	// Ignore holds file name patterns skipped while watching.
+	Ignore []string
+}
+
+// NewWatcher returns a watcher for the shares of the driver.
+func NewWatcher(d *Driver) *Watcher {
+	return &Watcher{
+		Driver:   d,
+		Interval: defaultWatchInterval,
+		Debounce: defaultWatchDebounce,
+		Ignore:   defaultWatchIgnore,
+	}
+}
+
+// Run watches the shared folders and forwards the changes to the guest until
+// stop is closed.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	client, err := dialSSH(w.Driver)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	agent, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("sh -c " + shQuote(touchAgent)); err != nil {
+		return err
+	}
+	defer agent.Close()
+
+	return w.watchShares(stop, func(changes []map[string]time.Time) error {
+		guestChanges := map[string]time.Time{}
+		for i, files := range changes {
+			for file, modTime := range files {
+				// Removed files have nothing left to touch
+				if modTime.IsZero() {
+					continue
+				}
+				if p, ok := guestPath(w.Driver.Shares[i], file); ok {
+					guestChanges[p] = modTime
+				}
+			}
+		}
+
+		log.Debugf("Forwarding %d changes to the guest", len(guestChanges))
+		if _, err := io.WriteString(agent, formatChanges(guestChanges)); err != nil {
+			return fmt.Errorf("Unable to forward changes to the guest: %s", err)
+		}
+		return nil
+	})
+}
+
+// watchShares watches the shared folders until stop is closed, with file
+// system notifications or by polling them when they are not available. Once
+// changes settle it calls flush with the modification time of the changed
+// files, indexed like the shares of the driver.
+func (w *Watcher) watchShares(stop <-chan struct{}, flush func(changes []map[string]time.Time) error) error {
+	shares := w.Driver.Shares
+	roots := make([]string, len(shares))
+	for i, share := range shares {
+		log.Infof("Watching %s for changes...", share.ShareFolder)
+		roots[i] = share.ShareFolder
+	}
+
+	notifyStop := make(chan struct{})
+	defer close(notifyStop)
+	notifications, err := notifyShares(roots, notifyStop)
+	if err != nil {
+		log.Debugf("Unable to watch the shared folders for notifications, polling them: %s", err)
+		return w.pollShares(stop, flush)
+	}
+
+	pending, forwarded := newPendingChanges(len(shares)), forwardedChanges{}
+	var lastChange time.Time
+	tick := w.Debounce / 4
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case file := <-notifications:
+			if i, modTime, ok := w.fileChange(file); ok && !forwarded.seen(file, modTime) {
+				pending[i][file] = modTime
+				lastChange = time.Now()
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		if !lastChange.IsZero() && time.Since(lastChange) >= w.Debounce {
+			if err := flush(pending); err != nil {
+				return err
+			}
+			forwarded.record(pending)
+			pending, lastChange = newPendingChanges(len(shares)), time.Time{}
+		}
+	}
+}
+
+// fileChange returns the share of a file reported by a notification and its
+// modification time, zero when it was removed. Directories and ignored files
+// are skipped.
+func (w *Watcher) fileChange(file string) (int, time.Time, bool) {
+	for i, share := range w.Driver.Shares {
+		rel, err := filepath.Rel(share.ShareFolder, file)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		for _, name := range strings.Split(rel, string(filepath.Separator)) {
+			if ignored(name, w.Ignore) {
+				return 0, time.Time{}, false
+			}
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return i, time.Time{}, true
+		}
+		if info.IsDir() {
+			return 0, time.Time{}, false
+		}
+		return i, info.ModTime(), true
+	}
+	return 0, time.Time{}, false
+}
+
+// forwardedChanges holds the modification time, in seconds as the guest
+// agent sets it, of the files last forwarded. Touching a file in the guest
+// can write its time back to the host file and raise a new notification.
+type forwardedChanges map[string]int64
+
+// seen tells whether the change of a file was forwarded already.
+func (f forwardedChanges) seen(file string, modTime time.Time) bool {
+	forwarded, ok := f[file]
+	return ok && !modTime.IsZero() && forwarded == modTime.Unix()
+}
+
+func (f forwardedChanges) record(changes []map[string]time.Time) {
+	for _, files := range changes {
+		for file, modTime := range files {
+			if modTime.IsZero() {
+				delete(f, file)
+				continue
+			}
+			f[file] = modTime.Unix()
+		}
+	}
+}
+
+func newPendingChanges(n int) []map[string]time.Time {
+	pending := make([]map[string]time.Time, n)
+	for i := range pending {
+		pending[i] = map[string]time.Time{}
+	}
+	return pending
+}
+
+// pollShares scans the shared folders every interval until stop is closed.
+func (w *Watcher) pollShares(stop <-chan struct{}, flush func(changes []map[string]time.Time) error) error {
+	shares := w.Driver.Shares
+	states := make([]map[string]fileState, len(shares))
+	for i, share := range shares {
+		states[i] = scanTree(share.ShareFolder, w.Ignore)
+	}
+
+	pending, changed := newPendingChanges(len(shares)), false
+	var lastChange time.Time
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		for i, share := range shares {
+			current := scanTree(share.ShareFolder, w.Ignore)
+			for _, file := range changedFiles(states[i], current) {
+				pending[i][file] = current[file].modTime
+				changed = true
+				lastChange = time.Now()
+			}
+			states[i] = current
+		}
+
+		if changed && time.Since(lastChange) >= w.Debounce {
+			if err := flush(pending); err != nil {
+				return err
+			}
+			pending, changed = newPendingChanges(len(shares)), false
+		}
+	}
+}
+
+// scanTree returns the state of every file under root, skipping the files
+// and directories whose name matches an ignore pattern.
+func scanTree(root string, ignore []string) map[string]fileState {
+	files := map[string]fileState{}
+	filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if file != root && ignored(info.Name(), ignore) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+func ignored(name string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFiles returns the files created, modified or removed between two
+// scans.
+func changedFiles(previous, current map[string]fileState) []string {
+	var changed []string
+	for file, state := range current {
+		if old, ok := previous[file]; !ok || old != state {
+			changed = append(changed, file)
+		}
+	}
+	for file := range previous {
+		if _, ok := current[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// guestPath maps a host file of the share to its path in the guest.
+func guestPath(share Share, file string) (string, bool) {
+	rel, err := filepath.Rel(share.ShareFolder, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Join(share.GuestFolder, filepath.ToSlash(rel)), true
+}
+
+// formatChanges returns the input of the guest agent for the changes.
+func formatChanges(changes map[string]time.Time) string {
+	paths := make([]string, 0, len(changes))
+	for p := range changes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%d %s\n", changes[p].Unix(), p)
+	}
+	return b.String()
+}
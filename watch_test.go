@@ -0,0 +1,102 @@
+package vmwareworkstation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanTreeChanges(t *testing.T) {
+	root, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "src", "old.go"), nil, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, ".git", "index"), nil, 0644))
+
+	before := scanTree(root, defaultWatchIgnore)
+	assert.Len(t, before, 2)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "README"), nil, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, ".git", "index"), []byte("changed"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(root, "src", "old.go")))
+
+	changed := changedFiles(before, scanTree(root, defaultWatchIgnore))
+
+	assert.Equal(t, []string{filepath.Join(root, "README"), filepath.Join(root, "src", "main.go"), filepath.Join(root, "src", "old.go")}, changed)
+}
+
+func TestGuestPath(t *testing.T) {
+	share := Share{Name: "src", ShareFolder: filepath.Join("home", "me", "src"), GuestFolder: "/src"}
+
+	p, ok := guestPath(share, filepath.Join("home", "me", "src", "cmd", "main.go"))
+	assert.True(t, ok)
+	assert.Equal(t, "/src/cmd/main.go", p)
+
+	_, ok = guestPath(share, filepath.Join("home", "me", "other"))
+	assert.False(t, ok)
+}
+
+func TestFormatChanges(t *testing.T) {
+	changes := map[string]time.Time{
+		"/src/b.go":   time.Unix(200, 0),
+		"/src/a b.go": time.Unix(100, 0),
+	}
+
+	assert.Equal(t, "100 /src/a b.go\n200 /src/b.go\n", formatChanges(changes))
+}
+
+func TestFileChange(t *testing.T) {
+	root, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "node_modules", "left-pad"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "main.go"), nil, 0644))
+
+	driver := NewDriver("default", "path").(*Driver)
+	driver.Shares = []Share{{Name: "other", ShareFolder: filepath.Join(root, "other")}, {Name: "src", ShareFolder: root}}
+	w := NewWatcher(driver)
+	w.Ignore = []string{"node_modules"}
+
+	i, modTime, ok := w.fileChange(filepath.Join(root, "main.go"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, i)
+	assert.False(t, modTime.IsZero())
+
+	i, modTime, ok = w.fileChange(filepath.Join(root, "removed.go"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, i)
+	assert.True(t, modTime.IsZero())
+
+	_, _, ok = w.fileChange(filepath.Join(root, "node_modules", "left-pad", "index.js"))
+	assert.False(t, ok)
+	_, _, ok = w.fileChange(filepath.Join(root, "node_modules"))
+	assert.False(t, ok)
+	_, _, ok = w.fileChange(filepath.Join(os.TempDir(), "elsewhere.go"))
+	assert.False(t, ok)
+}
+
+func TestForwardedChanges(t *testing.T) {
+	forwarded := forwardedChanges{}
+	modTime := time.Unix(100, 500)
+
+	assert.False(t, forwarded.seen("/src/main.go", modTime))
+
+	forwarded.record([]map[string]time.Time{{"/src/main.go": modTime, "/src/old.go": {}}})
+
+	// The guest touch writes the time back without the nanoseconds
+	assert.True(t, forwarded.seen("/src/main.go", time.Unix(100, 0)))
+	assert.False(t, forwarded.seen("/src/main.go", time.Unix(101, 0)))
+	assert.False(t, forwarded.seen("/src/main.go", time.Time{}))
+
+	forwarded.record([]map[string]time.Time{{"/src/main.go": {}}})
+	assert.False(t, forwarded.seen("/src/main.go", time.Unix(100, 0)))
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2015 Gonzalo Peci  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package vmwareworkstation
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const notifyMask = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+	syscall.FILE_NOTIFY_CHANGE_SIZE | syscall.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// notifyShares watches the directory trees with ReadDirectoryChangesW and
+// sends the paths of the changed files until stop is closed.
+func notifyShares(roots []string, stop <-chan struct{}) (<-chan string, error) {
+	var handles []syscall.Handle
+	for _, root := range roots {
+		p, err := syscall.UTF16PtrFromString(root)
+		if err != nil {
+			closeHandles(handles)
+			return nil, err
+		}
+		h, err := syscall.CreateFile(p, syscall.FILE_LIST_DIRECTORY,
+			syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+			nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+		if err != nil {
+			closeHandles(handles)
+			return nil, err
+		}
+		handles = append(handles, h)
+	}
+
+	changes := make(chan string)
+	for i, h := range handles {
+		go readChanges(h, roots[i], changes, stop)
+	}
+	go func() {
+		<-stop
+		// Unblocks the pending reads, each reader closes its handle
+		for _, h := range handles {
+			syscall.CancelIoEx(h, nil)
+		}
+	}()
+	return changes, nil
+}
+
+func readChanges(h syscall.Handle, root string, changes chan<- string, stop <-chan struct{}) {
+	defer syscall.CloseHandle(h)
+
+	buf := make([]byte, 64*1024)
+	for {
+		var n uint32
+		if err := syscall.ReadDirectoryChanges(h, &buf[0], uint32(len(buf)), true, notifyMask, &n, nil, 0); err != nil {
+			select {
+			case <-stop:
+			default:
+				log.Warnf("Stopped watching %s: %s", root, err)
+			}
+			return
+		}
+		if n == 0 {
+			log.Debugf("Too many changes in %s, some were not forwarded", root)
+			continue
+		}
+
+		for offset := uint32(0); ; {
+			info := (*syscall.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+			name := (*[32768]uint16)(unsafe.Pointer(&info.FileName))[: info.FileNameLength/2 : info.FileNameLength/2]
+			select {
+			case changes <- filepath.Join(root, syscall.UTF16ToString(name)):
+			case <-stop:
+				return
+			}
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
+
+func closeHandles(handles []syscall.Handle) {
+	for _, h := range handles {
+		syscall.CloseHandle(h)
+	}
+}
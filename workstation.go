@@ -10,21 +10,24 @@ package vmwareworkstation
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
 	"github.com/docker/machine/libmachine/mcnutils"
-	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 	cryptossh "golang.org/x/crypto/ssh"
 )
@@ -40,8 +43,27 @@ const (
 	defaultDiskSize = 20000
 	defaultCpus     = 1
 	defaultMemory   = 1024
+
+	defaultShareMountOptions = "allow_other"
+
+	removeAttempts = 3
+	stopTimeout    = 30
+
+	knownHostFilename = "known_host"
+	sshDialTimeout    = 10 * time.Second
+	sshCommandTimeout = 5 * time.Minute
+
+	// breakLocksEnv is the environment variable of the break locks flag,
+	// also read by Remove.
+	breakLocksEnv = "WORKSTATION_BREAK_LOCKS"
+
+	// guiModeEnv overrides the GUI mode of the machine for one invocation,
+	// gui or nogui.
+	guiModeEnv = "WORKSTATION_GUI_MODE"
 )
 
+var ErrHostKeyMismatch = errors.New("SSH host key of the guest does not match the pinned key")
+
 // Driver for VMware Workstation
 type Driver struct {
 	*drivers.BaseDriver
@@ -53,14 +75,54 @@ type Driver struct {
 	CPUS           int
 
 	SSHPassword    string
+	SSHKey         string
+	CACerts        []string
+	AuthorizedKeys []string
+	GuestUser      string
+	GuestPassword  string
 	ConfigDriveISO string
 	ConfigDriveURL string
-
-	NoShare         bool
-	ShareName       string
-	ShareFolder     string
-	GuestFolder     string
-	GuestCompatLink string
+	UserdataDir    string
+
+	NoShare           bool
+	Shares            []Share
+	ShareDriver       string
+	ShareUser         string
+	SharePassword     string
+	SyncIgnore        []string
+	GuestCompatLink   string
+	ShareUID          int
+	ShareGID          int
+	ShareUmask        string
+	ShareMountOptions string
+
+	// Deprecated: the single share of the machines created before
+	// --vmwareworkstation-share, only read from their config.json and moved
+	// to Shares by migrateShares.
+	ShareName   string
+	ShareFolder string
+	GuestFolder string
+
+	BreakLocks bool
+
+	Hooks        []Hook
+	GuestScripts []GuestScript
+	VMXOptions   map[string]string
+	VMXTemplate  string
+
+	HardwareVersion      int
+	GuestOS              string
+	CoresPerSocket       int
+	NestedVirtualization bool
+	PerformanceCounters  bool
+	Profile              string
+	Firmware             string
+	SerialLog            bool
+	GUI                  bool
+
+	VNC         bool
+	VNCPort     int
+	VNCPassword string
 }
 
 // GetCreateFlags registers the flags this driver adds to
@@ -79,6 +141,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "VMWare Workstation URL for cloud-init configdrive",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_USERDATA_DIR",
+			Name:   "vmwareworkstation-userdata-dir",
+			Usage:  "Directory merged into the boot2docker userdata.tar",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "WORKSTATION_CPU_COUNT",
 			Name:   "vmwareworkstation-cpu-count",
@@ -97,6 +164,64 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "VMWare Workstation size of disk for host VM (in MB)",
 			Value:  defaultDiskSize,
 		},
+		mcnflag.IntFlag{
+			EnvVar: "WORKSTATION_HARDWARE_VERSION",
+			Name:   "vmwareworkstation-hardware-version",
+			Usage:  "Virtual hardware version of the VM (0 to use the newest one the installed Workstation supports)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_GUEST_OS",
+			Name:   "vmwareworkstation-guest-os",
+			Usage:  "VMware guest OS identifier of the VM",
+			Value:  defaultGuestOS,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_FIRMWARE",
+			Name:   "vmwareworkstation-firmware",
+			Usage:  "Firmware of the VM: bios, efi or efi-secureboot",
+			Value:  firmwareBIOS,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_GUI",
+			Name:   "vmwareworkstation-gui",
+			Usage:  "Open the VM window in VMware Workstation instead of running it headless",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_VNC",
+			Name:   "vmwareworkstation-vnc",
+			Usage:  "Enable the VNC console of the VM",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "WORKSTATION_VNC_PORT",
+			Name:   "vmwareworkstation-vnc-port",
+			Usage:  "Port of the VNC console (0 to pick a free one from 5901 to 5999)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_VNC_PASSWORD",
+			Name:   "vmwareworkstation-vnc-password",
+			Usage:  "Password of the VNC console, up to 8 characters, required with --vmwareworkstation-vnc",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_SERIAL_LOG",
+			Name:   "vmwareworkstation-serial-log",
+			Usage:  "Log the serial console of the VM to serial.log in the machine dir",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "WORKSTATION_CORES_PER_SOCKET",
+			Name:   "vmwareworkstation-cores-per-socket",
+			Usage:  "Number of cores per virtual CPU socket, it must divide the CPU count (0 for one core per socket)",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_NESTED_VIRTUALIZATION",
+			Name:   "vmwareworkstation-nested-virtualization",
+			Usage:  "Expose hardware virtualization to the guest to run hypervisors such as KVM in it",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_PERFORMANCE_COUNTERS",
+			Name:   "vmwareworkstation-performance-counters",
+			Usage:  "Expose the CPU performance counters to the guest",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "WORKSTATION_SSH_USER",
 			Name:   "vmwareworkstation-ssh-user",
@@ -109,6 +234,31 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "SSH password",
 			Value:  defaultSSHPass,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SSH_KEY_PATH",
+			Name:   "vmwareworkstation-ssh-key-path",
+			Usage:  "Existing private SSH key to use instead of generating one",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_AUTHORIZED_KEYS",
+			Name:   "vmwareworkstation-authorized-keys",
+			Usage:  "Additional authorized_keys file or directory of *.pub files for the guest, can be repeated",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_CA_CERT",
+			Name:   "vmwareworkstation-ca-cert",
+			Usage:  "PEM CA certificate file or directory of *.pem and *.crt files trusted by the guest, can be repeated",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_GUEST_USER",
+			Name:   "vmwareworkstation-guest-user",
+			Usage:  "Guest user for VMware Tools operations (defaults to the SSH user)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_GUEST_PASSWORD",
+			Name:   "vmwareworkstation-guest-password",
+			Usage:  "Guest password for VMware Tools operations (defaults to the SSH password)",
+		},
 		mcnflag.BoolFlag{
 			EnvVar: "WORKSTATION_NO_SHARE",
 			Name:   "vmwareworkstation-no-share",
@@ -119,20 +269,112 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwareworkstation-share-folder",
 			Usage:  "Mount the specified directory instead of the default home location. Format: name:dir",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_SHARE",
+			Name:   "vmwareworkstation-share",
+			Usage:  "Share a host directory with the guest, can be repeated. Format: name=hostdir:guestdir[:ro]",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SHARE_DRIVER",
+			Name:   "vmwareworkstation-share-driver",
+			Usage:  "Driver used to share folders with the guest: hgfs, nfs, smb, sshfs or rsync",
+			Value:  shareDriverHGFS,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SHARE_USER",
+			Name:   "vmwareworkstation-share-user",
+			Usage:  "Host user the guest authenticates as with the smb and sshfs share drivers (defaults to the current user)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SHARE_PASSWORD",
+			Name:   "vmwareworkstation-share-password",
+			Usage:  "Password of the host user for the smb share driver",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_SYNC_IGNORE",
+			Name:   "vmwareworkstation-sync-ignore",
+			Usage:  "File name pattern the rsync share driver does not copy, can be repeated",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "WORKSTATION_SHARE_UID",
+			Name:   "vmwareworkstation-share-uid",
+			Usage:  "Owner uid of the files in the shared folders (-1 to keep the default)",
+			Value:  -1,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "WORKSTATION_SHARE_GID",
+			Name:   "vmwareworkstation-share-gid",
+			Usage:  "Owner gid of the files in the shared folders (-1 to keep the default)",
+			Value:  -1,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SHARE_UMASK",
+			Name:   "vmwareworkstation-share-umask",
+			Usage:  "Octal umask applied to the files in the shared folders",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_SHARE_MOUNT_OPTIONS",
+			Name:   "vmwareworkstation-share-mount-options",
+			Usage:  "Comma separated vmhgfs-fuse mount options for the shared folders",
+			Value:  defaultShareMountOptions,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "WORKSTATION_SHARE_COMPAT",
 			Name:   "vmwareworkstation-share-compat",
 			Usage:  "Override the compatibility link created by this driver",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_GUEST_SHARE_LINK",
+			Name:   "vmwareworkstation-guest-share-link",
+			Usage:  "Deprecated alias of --vmwareworkstation-share-compat",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_HOOK",
+			Name:   "vmwareworkstation-hook",
+			Usage:  "Host command run on a machine event, can be repeated. Format: event=command, events: pre-create, post-create, pre-start, post-start, pre-stop, post-remove",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_PROFILE",
+			Name:   "vmwareworkstation-profile",
+			Usage:  "VMX settings preset: default, performance or minimal",
+			Value:  defaultProfile,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WORKSTATION_VMX_TEMPLATE",
+			Name:   "vmwareworkstation-vmx-template",
+			Usage:  "Go text/template file rendered into the VMX file instead of the built-in template",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_VMX_OPTION",
+			Name:   "vmwareworkstation-vmx-option",
+			Usage:  "Extra VMX setting, can be repeated. Format: key=value",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WORKSTATION_GUEST_SCRIPT",
+			Name:   "vmwareworkstation-guest-script",
+			Usage:  "Script run in the guest over SSH after create, or after every start with :always, can be repeated. Format: path[:once|:always]",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WORKSTATION_BREAK_LOCKS",
+			Name:   "vmwareworkstation-break-locks",
+			Usage:  "Remove stale .lck directories left by a crashed VM when removing it",
+		},
 	}
 }
 
 func NewDriver(hostName, storePath string) drivers.Driver {
 	return &Driver{
-		CPUS:        defaultCpus,
-		Memory:      defaultMemory,
-		DiskSize:    defaultDiskSize,
-		SSHPassword: defaultSSHPass,
+		CPUS:              defaultCpus,
+		Memory:            defaultMemory,
+		DiskSize:          defaultDiskSize,
+		GuestOS:           defaultGuestOS,
+		Profile:           defaultProfile,
+		Firmware:          firmwareBIOS,
+		SSHPassword:       defaultSSHPass,
+		ShareDriver:       shareDriverHGFS,
+		ShareUID:          -1,
+		ShareGID:          -1,
+		ShareMountOptions: defaultShareMountOptions,
 		BaseDriver: &drivers.BaseDriver{
 			SSHUser:     defaultSSHUser,
 			MachineName: hostName,
@@ -141,6 +383,60 @@ func NewDriver(hostName, storePath string) drivers.Driver {
 	}
 }
 
+// LoadDriver loads the driver of an existing machine from the Docker Machine
+// store, which defaults to ~/.docker/machine like in docker-machine.
+func LoadDriver(storePath, machineName string) (*Driver, error) {
+	if storePath == "" {
+		storePath = os.Getenv("MACHINE_STORAGE_PATH")
+	}
+	if storePath == "" {
+		storePath = filepath.Join(mcnutils.GetHomeDir(), ".docker", "machine")
+	}
+
+	config, err := ioutil.ReadFile(filepath.Join(storePath, "machines", machineName, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var host struct {
+		DriverName string
+		Driver     json.RawMessage
+	}
+	if err := json.Unmarshal(config, &host); err != nil {
+		return nil, err
+	}
+
+	d := NewDriver(machineName, storePath).(*Driver)
+	if host.DriverName != d.DriverName() {
+		return nil, fmt.Errorf("machine %s uses the %s driver", machineName, host.DriverName)
+	}
+	if err := json.Unmarshal(host.Driver, d); err != nil {
+		return nil, err
+	}
+	d.migrateShares()
+	return d, nil
+}
+
+// guestCredentials returns the user and password of the VMware Tools guest
+// operations, the SSH ones unless guest credentials were configured.
+func (d *Driver) guestCredentials() (string, string) {
+	user, password := d.GuestUser, d.GuestPassword
+	if user == "" {
+		user = d.GetSSHUsername()
+	}
+	if password == "" {
+		password = d.SSHPassword
+	}
+	return user, password
+}
+
+// vmrunInGuest runs a vmrun guest operation on the VM with the guest
+// credentials.
+func (d *Driver) vmrunInGuest(command string, args ...string) (string, string, error) {
+	user, password := d.guestCredentials()
+	return vmrun(append([]string{"-gu", user, "-gp", password, command, d.vmxPath()}, args...)...)
+}
+
 func (d *Driver) GetSSHHostname() (string, error) {
 	return d.GetIP()
 }
@@ -164,51 +460,137 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.DiskSize = flags.Int("vmwareworkstation-disk-size")
 	d.Boot2DockerURL = flags.String("vmwareworkstation-boot2docker-url")
 	d.ConfigDriveURL = flags.String("vmwareworkstation-configdrive-url")
+	d.UserdataDir = flags.String("vmwareworkstation-userdata-dir")
+	if d.UserdataDir != "" {
+		if d.ConfigDriveURL != "" {
+			return fmt.Errorf("--vmwareworkstation-userdata-dir only applies to boot2docker, not to configdrive guests")
+		}
+		if info, err := os.Stat(d.UserdataDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("userdata dir %q is not a directory", d.UserdataDir)
+		}
+	}
 	d.ISO = d.ResolveStorePath(isoFilename)
 	d.ConfigDriveISO = d.ResolveStorePath(isoConfigDrive)
 	d.SetSwarmConfigFromFlags(flags)
 	d.SSHUser = flags.String("vmwareworkstation-ssh-user")
 	d.SSHPassword = flags.String("vmwareworkstation-ssh-password")
+	d.SSHKey = flags.String("vmwareworkstation-ssh-key-path")
+	if d.SSHKey != "" {
+		if _, err := os.Stat(d.SSHKey); err != nil {
+			return fmt.Errorf("Unable to use SSH key: %s", err)
+		}
+	}
+	d.AuthorizedKeys = flags.StringSlice("vmwareworkstation-authorized-keys")
+	for _, path := range d.AuthorizedKeys {
+		if _, err := readAuthorizedKeys(path); err != nil {
+			return err
+		}
+	}
+	d.CACerts = flags.StringSlice("vmwareworkstation-ca-cert")
+	if _, err := readCACerts(d.CACerts); err != nil {
+		return err
+	}
+	d.GuestUser = flags.String("vmwareworkstation-guest-user")
+	d.GuestPassword = flags.String("vmwareworkstation-guest-password")
 	d.SSHPort = 22
+	d.BreakLocks = flags.Bool("vmwareworkstation-break-locks")
 
-	// We support a maximum of 16 cpu to be consistent with Virtual Hardware 10
-	// specs.
+	var err error
+	if d.Hooks, err = parseHooks(flags.StringSlice("vmwareworkstation-hook")); err != nil {
+		return err
+	}
+	if d.VMXOptions, err = parseVMXOptions(flags.StringSlice("vmwareworkstation-vmx-option")); err != nil {
+		return err
+	}
+	d.Profile = flags.String("vmwareworkstation-profile")
+	if err := checkProfile(d.Profile); err != nil {
+		return err
+	}
+	d.SerialLog = flags.Bool("vmwareworkstation-serial-log")
+	d.GUI = flags.Bool("vmwareworkstation-gui")
+	d.VNC = flags.Bool("vmwareworkstation-vnc")
+	d.VNCPort = flags.Int("vmwareworkstation-vnc-port")
+	d.VNCPassword = flags.String("vmwareworkstation-vnc-password")
+	if err := d.checkVNC(); err != nil {
+		return err
+	}
+	d.VMXTemplate = flags.String("vmwareworkstation-vmx-template")
+	if d.GuestScripts, err = parseGuestScripts(flags.StringSlice("vmwareworkstation-guest-script")); err != nil {
+		return err
+	}
+
+	// The CPU and memory limits depend on the virtual hardware version
 	if d.CPU < 1 {
 		d.CPU = int(runtime.NumCPU())
 	}
-	if d.CPU > 16 {
-		d.CPU = 16
+	d.HardwareVersion = flags.Int("vmwareworkstation-hardware-version")
+	d.GuestOS = flags.String("vmwareworkstation-guest-os")
+	d.Firmware = flags.String("vmwareworkstation-firmware")
+	d.CoresPerSocket = flags.Int("vmwareworkstation-cores-per-socket")
+	d.NestedVirtualization = flags.Bool("vmwareworkstation-nested-virtualization")
+	d.PerformanceCounters = flags.Bool("vmwareworkstation-performance-counters")
+	if err := d.checkHardware(installedHardwareVersion()); err != nil {
+		return err
 	}
 
-	d.NoShare = flags.Bool("vmwareworkstation-share")
-	switch runtime.GOOS {
-
-	case "linux": // TODO Test linux working
-		d.ShareName = "Home"
-		d.ShareFolder = "/home"
-		d.GuestFolder = "/Users"
-	case "windows":
-		d.ShareName = "Users"
-		d.ShareFolder = `C:\Users\`
-		d.GuestFolder = "/Users"
-		d.GuestCompatLink = "/c/Users"
-	}
+	d.NoShare = flags.Bool("vmwareworkstation-no-share")
+	d.Shares, d.GuestCompatLink = defaultShares()
 
 	if flags.String("vmwareworkstation-share-folder") != "" {
-		d.ShareName, d.ShareFolder = parseShareFolder(flags.String("vmwareworkstation-share-folder"))
+		name, folder, err := parseShareFolder(flags.String("vmwareworkstation-share-folder"))
+		if err != nil {
+			return err
+		}
+		d.Shares = []Share{{Name: name, ShareFolder: folder, GuestFolder: "/Users"}}
+	}
+	if shares := flags.StringSlice("vmwareworkstation-share"); len(shares) > 0 {
+		if d.Shares, err = parseShares(shares); err != nil {
+			return err
+		}
+		d.GuestCompatLink = ""
 	}
+	// guest-share-link is the name the flag used to be read with
 	if flags.String("vmwareworkstation-guest-share-link") != "" {
+		log.Warnf("--vmwareworkstation-guest-share-link is deprecated, use --vmwareworkstation-share-compat")
 		d.GuestCompatLink = flags.String("vmwareworkstation-guest-share-link")
 	}
+	if flags.String("vmwareworkstation-share-compat") != "" {
+		d.GuestCompatLink = flags.String("vmwareworkstation-share-compat")
+	}
 
-	return nil
-}
+	d.ShareDriver = flags.String("vmwareworkstation-share-driver")
+	if _, ok := shareBackends[d.ShareDriver]; !ok && d.ShareDriver != shareDriverHGFS && d.ShareDriver != shareDriverRsync {
+		return fmt.Errorf("invalid share driver %q, it must be one of hgfs, nfs, smb, sshfs or rsync", d.ShareDriver)
+	}
+	d.SyncIgnore = flags.StringSlice("vmwareworkstation-sync-ignore")
+	d.ShareUser = flags.String("vmwareworkstation-share-user")
+	if d.ShareUser == "" {
+		d.ShareUser = defaultShareUser()
+	}
+	d.SharePassword = flags.String("vmwareworkstation-share-password")
+	if d.ShareDriver == shareDriverSMB && d.SharePassword == "" {
+		return fmt.Errorf("the smb share driver needs --vmwareworkstation-share-password")
+	}
+
+	d.ShareUID = flags.Int("vmwareworkstation-share-uid")
+	d.ShareGID = flags.Int("vmwareworkstation-share-gid")
+	d.ShareUmask = flags.String("vmwareworkstation-share-umask")
+	d.ShareMountOptions = flags.String("vmwareworkstation-share-mount-options")
+	if d.ShareUmask != "" {
+		if _, err := strconv.ParseUint(d.ShareUmask, 8, 32); err != nil {
+			return fmt.Errorf("invalid share umask %q, it must be an octal number", d.ShareUmask)
+		}
+	}
 
-func parseShareFolder(shareFolder string) (string, string) {
-	split := strings.Split(shareFolder, ":")
-	ShareFolder := strings.Join(split[1:len(split)], ":")
-	ShareName := split[0]
-	return ShareFolder, ShareName
+	if d.VMXTemplate != "" || d.SerialLog {
+		// Catch template errors, or a profile dropping the serial port,
+		// once everything the VMX is rendered from is set
+		if _, err := d.renderVMX(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (d *Driver) GetURL() (string, error) {
@@ -264,6 +646,21 @@ func (d *Driver) PreCreateCheck() error {
 }
 
 func (d *Driver) Create() error {
+	if err := d.runHooks(HookPreCreate); err != nil {
+		return err
+	}
+	if err := d.create(); err != nil {
+		return err
+	}
+	if err := d.runGuestScripts(true); err != nil {
+		return err
+	}
+
+	d.runPostHooks(HookPostCreate)
+	return nil
+}
+
+func (d *Driver) create() error {
 	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
 	if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
 		return err
@@ -276,13 +673,12 @@ func (d *Driver) Create() error {
 		}
 	}
 
-	log.Infof("Creating SSH key...")
-	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+	log.Infof("Creating VM...")
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
 		return err
 	}
 
-	log.Infof("Creating VM...")
-	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+	if err := d.setupSSHKey(); err != nil {
 		return err
 	}
 
@@ -290,13 +686,18 @@ func (d *Driver) Create() error {
 		return ErrMachineExist
 	}
 
+	if err := d.setupVNC(); err != nil {
+		return err
+	}
+
 	// Generate vmx config file from template
-	vmxt := template.Must(template.New("vmx").Parse(vmx))
-	vmxfile, err := os.Create(d.vmxPath())
+	vmxContent, err := d.renderVMX()
 	if err != nil {
 		return err
 	}
-	vmxt.Execute(vmxfile, d)
+	if err := ioutil.WriteFile(d.vmxPath(), vmxContent, 0644); err != nil {
+		return err
+	}
 
 	// Generate vmdk file
 	diskImg := d.ResolveStorePath(fmt.Sprintf("%s.vmdk", d.MachineName))
@@ -311,70 +712,32 @@ func (d *Driver) Create() error {
 	}
 
 	log.Infof("Starting %s...", d.MachineName)
-	vmrun("start", d.vmxPath(), "nogui")
-
-	var ip string
-
-	log.Infof("Waiting for VM to come online...")
-	for i := 1; i <= 60; i++ {
-		ip, err = d.getIPfromDHCPLease()
-		if err != nil {
-			log.Debugf("Not there yet %d/%d, error: %s", i, 60, err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-
-		if ip != "" {
-			log.Debugf("Got an ip: %s", ip)
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, 22), time.Duration(2*time.Second))
-			if err != nil {
-				log.Debugf("SSH Daemon not responding yet: %s", err)
-				time.Sleep(2 * time.Second)
-				continue
-			}
-			conn.Close()
-			break
-		}
+	if err := d.startVM(); err != nil {
+		return err
 	}
 
-	if ip == "" {
-		return fmt.Errorf("Machine didn't return an IP after 120 seconds, aborting")
+	if err := d.waitForIP(); err != nil {
+		return err
 	}
 
 	// we got an IP, let's copy ssh keys over
-	d.IPAddress = ip
 
 	// Do not execute the rest of boot2docker specific configuration
 	// The uplaod of the public ssh key uses a ssh connection,
 	// this works without installed vmware client tools
 	if d.ConfigDriveURL != "" {
-		var keyfh *os.File
-		var keycontent []byte
-
 		log.Infof("Copy public SSH key to %s [%s]", d.MachineName, d.IPAddress)
 
-		// create .ssh folder in users home
-		if err := executeSSHCommand(fmt.Sprintf("mkdir -p /home/%s/.ssh", d.SSHUser), d); err != nil {
+		keycontent, err := d.authorizedKeys()
+		if err != nil {
 			return err
 		}
-
-		// read generated public ssh key
-		if keyfh, err = os.Open(d.publicSSHKeyPath()); err != nil {
+		if err := d.installAuthorizedKey(keycontent); err != nil {
 			return err
 		}
-		defer keyfh.Close()
+		d.configureSerialConsole()
 
-		if keycontent, err = ioutil.ReadAll(keyfh); err != nil {
-			return err
-		}
-
-		// add public ssh key to authorized_keys
-		if err := executeSSHCommand(fmt.Sprintf("echo '%s' > /home/%s/.ssh/authorized_keys", string(keycontent), d.SSHUser), d); err != nil {
-			return err
-		}
-
-		// make it secure
-		if err := executeSSHCommand(fmt.Sprintf("chmod 600 /home/%s/.ssh/authorized_keys", d.SSHUser), d); err != nil {
+		if err := d.configureGuest(); err != nil {
 			return err
 		}
 
@@ -387,50 +750,77 @@ func (d *Driver) Create() error {
 		return err
 	}
 
+	// Every step below runs through VMware Tools with the guest credentials
+	if err := d.waitForTools(); err != nil {
+		return err
+	}
+
 	// Test if /var/lib/boot2docker exists
-	vmrun("-gu", B2DUser, "-gp", B2DPass, "directoryExistsInGuest", d.vmxPath(), "/var/lib/boot2docker")
+	d.vmrunInGuest("directoryExistsInGuest", "/var/lib/boot2docker")
 
 	// Copy SSH keys bundle
-	vmrun("-gu", B2DUser, "-gp", B2DPass, "CopyFileFromHostToGuest", d.vmxPath(), d.ResolveStorePath("userdata.tar"), "/home/docker/userdata.tar")
+	if stdout, _, err := d.vmrunInGuest("CopyFileFromHostToGuest", d.ResolveStorePath("userdata.tar"), "/tmp/userdata.tar"); err != nil {
+		return fmt.Errorf("Unable to copy the SSH keys bundle to the guest: %s", guestError(stdout, err))
+	}
 
 	// Expand tar file.
-	vmrun("-gu", B2DUser, "-gp", B2DPass, "runScriptInGuest", d.vmxPath(), "/bin/sh", "sudo /bin/mv /home/docker/userdata.tar /var/lib/boot2docker/userdata.tar && sudo tar xf /var/lib/boot2docker/userdata.tar -C /home/docker/ > /var/log/userdata.log 2>&1 && sudo chown -R docker:staff /home/docker")
+	home := "/home/" + d.SSHUser
+	if err := d.runScriptInGuest(fmt.Sprintf(
+		"sudo /bin/mv /tmp/userdata.tar /var/lib/boot2docker/userdata.tar && sudo tar xf /var/lib/boot2docker/userdata.tar -C %s > /var/log/userdata.log 2>&1 && sudo chown -R %s:$(id -gn %s) %s",
+		home, d.SSHUser, d.SSHUser, home,
+	)); err != nil {
+		return fmt.Errorf("Unable to install the SSH keys bundle: %s", err)
+	}
 
-	if !d.NoShare {
-		// Enable Shared Folders
-		vmrun("-gu", B2DUser, "-gp", B2DPass, "enableSharedFolders", d.vmxPath())
-		if err := mountSharedFolder(d); err != nil {
-			return err
+	if d.UserdataDir != "" {
+		if err := d.runScriptInGuest(userdataBootCommand(home)); err != nil {
+			return fmt.Errorf("Unable to apply the boot2docker userdata files: %s", err)
 		}
-	} else {
-		log.Infof("No shared folders")
 	}
 
-	return nil
+	return d.configureGuest()
 }
 
 func (d *Driver) Start() error {
-	vmrun("start", d.vmxPath(), "nogui")
-
-	// Do not execute the rest of boot2docker specific configuration, exit here
-	if d.ConfigDriveURL != "" {
-		log.Debugf("Leaving start sequence early, configdrive found")
-		return nil
+	if err := d.runHooks(HookPreStart); err != nil {
+		return err
 	}
 
-	if !d.NoShare {
-		if err := mountSharedFolder(d); err != nil {
+	if s, _ := d.GetState(); s != state.Running {
+		if err := d.applyVMXOptions(); err != nil {
 			return err
 		}
-	} else {
-		log.Infof("No shared folders")
+		if err := d.startVM(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.configureGuest(); err != nil {
+		return err
+	}
+	if err := d.runGuestScripts(false); err != nil {
+		return err
 	}
 
+	d.runPostHooks(HookPostStart)
+	return nil
+}
+
+// startVM powers the VM on, explaining the failures vmware.log knows about.
+func (d *Driver) startVM() error {
+	stdout, _, err := vmrun("start", d.vmxPath(), d.guiMode())
+	if err != nil {
+		return d.withVMwareLog(fmt.Errorf("Unable to start %s: %s", d.MachineName, strings.TrimSpace(stdout)))
+	}
 	return nil
 }
 
 func (d *Driver) Stop() error {
-	_, _, err := vmrun("stop", d.vmxPath(), "nogui")
+	if err := d.runHooks(HookPreStop); err != nil {
+		return err
+	}
+
+	_, _, err := vmrun("stop", d.vmxPath())
 	return err
 }
 
@@ -440,28 +830,224 @@ func (d *Driver) Remove() error {
 		if err := d.Kill(); err != nil {
 			return fmt.Errorf("Error stopping VM before deletion")
 		}
+		if err := d.waitForStop(); err != nil {
+			return err
+		}
 	}
+
+	if locks := d.lockPaths(); len(locks) > 0 {
+		if d.breakLocks() {
+			for _, lock := range locks {
+				log.Infof("Breaking stale lock %s", lock)
+				if err := os.RemoveAll(lock); err != nil {
+					log.Warnf("Unable to remove lock %s: %s", lock, err)
+				}
+			}
+		} else {
+			log.Warnf("Found stale locks for %s, create it with --vmwareworkstation-break-locks or set %s=true to remove them: %s", d.MachineName, breakLocksEnv, strings.Join(locks, ", "))
+		}
+	}
+
 	log.Infof("Deleting %s...", d.MachineName)
-	vmrun("deleteVM", d.vmxPath(), "nogui")
+	var deleteErr error
+	for i := 1; i <= removeAttempts; i++ {
+		stdout, _, err := vmrun("deleteVM", d.vmxPath())
+		if err == nil {
+			deleteErr = nil
+			break
+		}
+		deleteErr = fmt.Errorf("%s: %s", err, strings.TrimSpace(stdout))
+		log.Debugf("deleteVM failed %d/%d: %s", i, removeAttempts, deleteErr)
+		if i < removeAttempts {
+			time.Sleep(2 * time.Second)
+		}
+	}
+	if deleteErr != nil {
+		log.Warnf("vmrun deleteVM failed, removing files manually: %s", deleteErr)
+	}
+
+	if err := d.cleanupNetworkShares(); err != nil {
+		log.Warnf("Unable to remove the %s shares from the host: %s", d.ShareDriver, err)
+	}
+
+	if err := d.removeLeftovers(); err != nil {
+		return err
+	}
+	d.runPostHooks(HookPostRemove)
 	return nil
 }
 
-func (d *Driver) Restart() error {
-	_, _, err := vmrun("reset", d.vmxPath(), "nogui")
+// breakLocks tells whether stale locks are removed, the flag is set on create
+// so the environment can also ask for it when removing the machine.
+func (d *Driver) breakLocks() bool {
+	if d.BreakLocks {
+		return true
+	}
+	breakLocks, _ := strconv.ParseBool(os.Getenv(breakLocksEnv))
+	return breakLocks
+}
+
+// configureGuest applies the guest configuration that does not survive a
+// boot or may have changed since the last one.
+func (d *Driver) configureGuest() error {
+	d.migrateShares()
 
-	if !d.NoShare {
-		if err := mountSharedFolder(d); err != nil {
+	if len(d.CACerts) > 0 {
+		if err := d.waitForIP(); err != nil {
+			return err
+		}
+		if err := d.installCACerts(); err != nil {
 			return err
 		}
-	} else {
+	}
+
+	return d.mountShares()
+}
+
+// mountShares mounts the configured shares in the guest with the selected
+// share driver. VMware shared folders are mounted through VMware Tools on
+// boot2docker and through systemd mount units on cloud-init guests, the
+// other share drivers mount over SSH. The rsync share driver copies the
+// shares instead.
+func (d *Driver) mountShares() error {
+	if d.NoShare {
 		log.Infof("No shared folders")
+		return nil
 	}
 
-	return err
+	if d.ShareDriver == shareDriverRsync {
+		if err := d.waitForIP(); err != nil {
+			return err
+		}
+		return NewSyncer(d).SyncAll()
+	}
+
+	if d.ShareDriver != "" && d.ShareDriver != shareDriverHGFS {
+		if err := d.waitForIP(); err != nil {
+			return err
+		}
+		return d.mountNetworkShares()
+	}
+
+	// Enable Shared Folders
+	d.vmrunInGuest("enableSharedFolders")
+
+	if d.ConfigDriveURL != "" {
+		// The mount units remount the shares on boot, only keep them in
+		// sync with the configuration
+		if err := d.waitForIP(); err != nil {
+			return err
+		}
+		return d.syncMountUnits()
+	}
+
+	return mountSharedFolder(d)
+}
+
+// waitForIP waits for the VM to get a DHCP lease and for its SSH daemon to
+// answer, then stores the IP address.
+func (d *Driver) waitForIP() error {
+	var ip string
+	var err error
+
+	log.Infof("Waiting for VM to come online...")
+	for i := 1; i <= 60; i++ {
+		ip, err = d.getIPfromDHCPLease()
+		if err != nil {
+			log.Debugf("Not there yet %d/%d, error: %s", i, 60, err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		if ip != "" {
+			log.Debugf("Got an ip: %s", ip)
+			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, 22), time.Duration(2*time.Second))
+			if err != nil {
+				log.Debugf("SSH Daemon not responding yet: %s", err)
+				time.Sleep(2 * time.Second)
+				continue
+			}
+			conn.Close()
+			break
+		}
+	}
+
+	if ip == "" {
+		return d.withVMwareLog(d.withSerialLog(fmt.Errorf("Machine didn't return an IP after 120 seconds, aborting")))
+	}
+
+	d.IPAddress = ip
+	return nil
+}
+
+// waitForStop polls the VM state until vmrun no longer lists it as running.
+func (d *Driver) waitForStop() error {
+	for i := 1; i <= stopTimeout; i++ {
+		if s, _ := d.GetState(); s != state.Running {
+			return nil
+		}
+		log.Debugf("VM still running %d/%d", i, stopTimeout)
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("Machine %s did not stop after %d seconds", d.MachineName, stopTimeout)
+}
+
+// lockPaths returns the .lck directories VMware left in the machine dir.
+func (d *Driver) lockPaths() []string {
+	locks, _ := filepath.Glob(d.ResolveStorePath("*.lck"))
+	return locks
+}
+
+// removeLeftovers removes whatever deleteVM left behind and reports the files
+// that could not be removed along with the reason.
+func (d *Driver) removeLeftovers() error {
+	paths := []string{
+		d.vmdkPath(),
+		d.vmxPath(),
+		d.ResolveStorePath(fmt.Sprintf("%s.nvram", d.MachineName)),
+		d.ResolveStorePath("userdata.tar"),
+		d.ResolveStorePath(knownHostFilename),
+		d.ISO,
+		d.ConfigDriveISO,
+	}
+	logs, _ := filepath.Glob(d.ResolveStorePath("*.log"))
+	paths = append(paths, logs...)
+
+	var leftovers []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			leftovers = append(leftovers, fmt.Sprintf("%s: %s", path, err))
+		}
+	}
+	for _, lock := range d.lockPaths() {
+		leftovers = append(leftovers, fmt.Sprintf("%s: stale lock, set %s=true to remove it", lock, breakLocksEnv))
+	}
+
+	if len(leftovers) > 0 {
+		return fmt.Errorf("Unable to remove all files of %s:\n%s", d.MachineName, strings.Join(leftovers, "\n"))
+	}
+	return nil
+}
+
+func (d *Driver) Restart() error {
+	if _, _, err := vmrun("reset", d.vmxPath()); err != nil {
+		return err
+	}
+
+	if err := d.configureGuest(); err != nil {
+		return err
+	}
+	return d.runGuestScripts(false)
 }
 
 func (d *Driver) Kill() error {
-	_, _, err := vmrun("stop", d.vmxPath(), "hard nogui")
+	_, _, err := vmrun("stop", d.vmxPath(), "hard")
 	return err
 }
 
@@ -469,6 +1055,24 @@ func (d *Driver) Upgrade() error {
 	return fmt.Errorf("VMware Workstation does not currently support the upgrade operation")
 }
 
+// guiMode returns how vmrun starts the VM, the GUI mode of the machine unless
+// overridden by the environment.
+func (d *Driver) guiMode() string {
+	mode := "nogui"
+	if d.GUI {
+		mode = "gui"
+	}
+
+	switch override := strings.ToLower(os.Getenv(guiModeEnv)); override {
+	case "":
+	case "gui", "nogui":
+		mode = override
+	default:
+		log.Warnf("Ignoring %s=%s, it must be gui or nogui", guiModeEnv, override)
+	}
+	return mode
+}
+
 func (d *Driver) vmxPath() string {
 	return d.ResolveStorePath(fmt.Sprintf("%s.vmx", d.MachineName))
 }
@@ -590,12 +1194,18 @@ func (d *Driver) generateKeyBundle() error {
 	if _, err := tw.Write([]byte(magicString)); err != nil {
 		return err
 	}
+	// The user files come before the keys so they cannot replace them
+	if d.UserdataDir != "" {
+		if err := addUserdataDir(tw, d.UserdataDir); err != nil {
+			return err
+		}
+	}
 	// .ssh/key.pub => authorized_keys
 	file = &tar.Header{Name: ".ssh", Typeflag: tar.TypeDir, Mode: 0700}
 	if err := tw.WriteHeader(file); err != nil {
 		return err
 	}
-	pubKey, err := ioutil.ReadFile(d.publicSSHKeyPath())
+	pubKey, err := d.authorizedKeys()
 	if err != nil {
 		return err
 	}
@@ -621,35 +1231,169 @@ func (d *Driver) generateKeyBundle() error {
 
 }
 
-// execute command over SSH with user / password authentication
-func executeSSHCommand(command string, d *Driver) error {
-	log.Debugf("Execute executeSSHCommand: %s", command)
+// addUserdataDir adds the content of dir to the userdata bundle, keeping the
+// file modes.
+func addUserdataDir(tw *tar.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		// Windows has no executable bit, keep shell scripts runnable
+		if runtime.GOOS == "windows" && strings.HasSuffix(header.Name, ".sh") {
+			header.Mode |= 0111
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
 
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// userdataDaemonConfig is the bootsync.sh line installing the daemon.json of
+// the userdata bundle where dockerd reads it, /etc is not persistent.
+const userdataDaemonConfig = "mkdir -p /etc/docker && cp /var/lib/boot2docker/daemon.json /etc/docker/daemon.json"
+
+// userdataBootCommand moves the boot2docker boot files, daemon.json and the
+// certs directory of the userdata bundle to the persistent partition and
+// applies them once, boot2docker picks them up by itself on the next boots.
+func userdataBootCommand(home string) string {
+	var commands []string
+	for _, file := range []string{"bootsync.sh", "bootlocal.sh", "profile", "daemon.json"} {
+		commands = append(commands, fmt.Sprintf(
+			"if [ -f %s/%s ]; then sudo mv %s/%s /var/lib/boot2docker/%s; fi",
+			home, file, home, file, file,
+		))
+	}
+	commands = append(commands,
+		// boot2docker adds the certificates of the persistent certs
+		// directory to its bundle on boot
+		fmt.Sprintf(
+			"if [ -d %s/certs ]; then for cert in %s/certs/*.pem %s/certs/*.crt; do if [ -f \"$cert\" ]; then cat \"$cert\"; fi; done | sudo tee -a /etc/ssl/certs/ca-certificates.crt > /dev/null && "+
+				"sudo mkdir -p /var/lib/boot2docker/certs && sudo cp -R %s/certs/. /var/lib/boot2docker/certs/ && rm -rf %s/certs; fi",
+			home, home, home, home, home,
+		),
+		fmt.Sprintf(
+			"if [ -f /var/lib/boot2docker/daemon.json ]; then sudo sh -c 'f=/var/lib/boot2docker/bootsync.sh; [ -f $f ] || printf \"#!/bin/sh\\n\" > $f; grep -qF \"%s\" $f || echo \"%s\" >> $f; chmod +x $f'; fi",
+			userdataDaemonConfig, userdataDaemonConfig,
+		),
+		"if [ -f /var/lib/boot2docker/bootsync.sh ]; then sudo /bin/sh /var/lib/boot2docker/bootsync.sh; fi",
+		"if [ -f /var/lib/boot2docker/profile ] || [ -f /var/lib/boot2docker/daemon.json ] || [ -d /var/lib/boot2docker/certs ]; then sudo /etc/init.d/docker restart; fi",
+		"if [ -f /var/lib/boot2docker/bootlocal.sh ]; then sudo /bin/sh /var/lib/boot2docker/bootlocal.sh > /dev/null 2>&1 & fi",
+	)
+	return strings.Join(commands, "; ")
+}
+
+// hostKeyCallback pins the host key of the guest the first time the driver
+// connects to it and rejects any other key afterwards.
+func (d *Driver) hostKeyCallback() cryptossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key cryptossh.PublicKey) error {
+		knownHost := d.ResolveStorePath(knownHostFilename)
+
+		content, err := ioutil.ReadFile(knownHost)
+		if os.IsNotExist(err) {
+			log.Debugf("Pinning the SSH host key of %s in %s", d.MachineName, knownHost)
+			return ioutil.WriteFile(knownHost, cryptossh.MarshalAuthorizedKey(key), 0600)
+		}
+		if err != nil {
+			return err
+		}
+
+		pinned, _, _, _, err := cryptossh.ParseAuthorizedKey(content)
+		if err != nil {
+			return fmt.Errorf("Unable to read the SSH host key pinned in %s: %s", knownHost, err)
+		}
+		if !bytes.Equal(pinned.Marshal(), key.Marshal()) {
+			return fmt.Errorf("%s: %s has a %s key, remove %s if the guest was reinstalled", ErrHostKeyMismatch, hostname, key.Type(), knownHost)
+		}
+		return nil
+	}
+}
+
+// sshAuthMethods returns the machine SSH key when it exists, then the SSH
+// password when one is configured.
+func (d *Driver) sshAuthMethods() []cryptossh.AuthMethod {
+	var methods []cryptossh.AuthMethod
+	if key, err := ioutil.ReadFile(d.GetSSHKeyPath()); err == nil {
+		if signer, err := cryptossh.ParsePrivateKey(key); err == nil {
+			methods = append(methods, cryptossh.PublicKeys(signer))
+		} else {
+			log.Debugf("Unable to parse SSH key %s: %s", d.GetSSHKeyPath(), err)
+		}
+	}
+	if d.SSHPassword != "" {
+		methods = append(methods, cryptossh.Password(d.SSHPassword))
+	}
+	return methods
+}
+
+// dialSSH opens an SSH connection to the guest with key or password
+// authentication.
+func dialSSH(d *Driver) (*cryptossh.Client, error) {
 	config := &cryptossh.ClientConfig{
-		User: d.SSHUser,
-		Auth: []cryptossh.AuthMethod{
-			cryptossh.Password(d.SSHPassword),
-		},
+		User:            d.GetSSHUsername(),
+		Auth:            d.sshAuthMethods(),
+		HostKeyCallback: d.hostKeyCallback(),
 	}
 
-	client, err := cryptossh.Dial("tcp", fmt.Sprintf("%s:%d", d.IPAddress, d.SSHPort), config)
+	addr := net.JoinHostPort(d.IPAddress, strconv.Itoa(d.SSHPort))
+	conn, err := net.DialTimeout("tcp", addr, sshDialTimeout)
 	if err != nil {
-		log.Debugf("Failed to dial:", err)
-		return err
+		log.Debugf("Failed to dial: %s", err)
+		return nil, err
 	}
 
-	session, err := client.NewSession()
+	// The deadline covers the handshake only
+	conn.SetDeadline(time.Now().Add(sshDialTimeout))
+	c, chans, reqs, err := cryptossh.NewClientConn(conn, addr, config)
 	if err != nil {
-		log.Debugf("Failed to create session: " + err.Error())
-		return err
+		conn.Close()
+		log.Debugf("Failed to dial: %s", err)
+		return nil, err
 	}
-	defer session.Close()
+	conn.SetDeadline(time.Time{})
 
-	var b bytes.Buffer
-	session.Stdout = &b
+	return cryptossh.NewClient(c, chans, reqs), nil
+}
+
+// execute command over SSH
+func executeSSHCommand(command string, d *Driver) error {
+	return executeSSHCommandInput(command, nil, d)
+}
+
+// executeSSHCommandInput runs a command over SSH with stdin as its input, so
+// content does not need to be quoted into the command.
+func executeSSHCommandInput(command string, stdin io.Reader, d *Driver) error {
+	log.Debugf("Execute executeSSHCommand: %s", command)
 
-	if err := session.Run(command); err != nil {
-		log.Debugf("Failed to run: " + err.Error())
+	var b bytes.Buffer
+	if err := runSSHCommand(d, command, stdin, &b, nil, sshCommandTimeout); err != nil {
+		log.Debugf("Failed to run: %s", err)
 		return err
 	}
 	log.Debugf("Stdout from executeSSHCommand: %s", b.String())
@@ -657,60 +1401,47 @@ func executeSSHCommand(command string, d *Driver) error {
 	return nil
 }
 
-func mountSharedFolder(d *Driver) error {
-	log.Infof("Mounting Shared Folders...")
-	if d.ShareFolder != "" {
-		if _, err := os.Stat(d.ShareFolder); err != nil && !os.IsNotExist(err) {
-			log.Error("Shared folder %s does not exist on host", d.ShareFolder)
-			return err
-		} else if !os.IsNotExist(err) {
-			// Add Share folder config so VMWare
-			log.Infof("Adding shared folder %s and mapping to /%s ...", d.ShareFolder, d.ShareName)
-			vmrun(
-				"-gu", B2DUser, "-gp", B2DPass, "addSharedFolder", d.vmxPath(),
-				d.ShareName,
-				d.ShareFolder,
-			)
-
-			// Create mountpoint and mount shared folder
-			commands := []string{
-				fmt.Sprintf("[ ! -d %q ] && sudo mkdir %q", d.GuestFolder, d.GuestFolder),
-				fmt.Sprintf(
-					"[ -f /usr/local/bin/vmhgfs-fuse ] && "+
-						"sudo /usr/local/bin/vmhgfs-fuse -o allow_other .host:/%v %q"+
-						" || "+
-						"sudo mount -t vmhgfs .host:/%v %q",
-					d.ShareName,
-					d.GuestFolder,
-					d.ShareName,
-					d.GuestFolder,
-				),
-			}
+// runSSHCommand runs a command over SSH and gives up after the timeout.
+func runSSHCommand(d *Driver, command string, stdin io.Reader, stdout, stderr io.Writer, timeout time.Duration) error {
+	client, err := dialSSH(d)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
-			if d.GuestCompatLink != "" {
-				// Add a compatibility symlink
-				compat_commands := []string{
-					fmt.Sprintf(
-						"[ ! -d %q ] && sudo mkdir -p %q",
-						d.GuestCompatLink,
-						d.GuestCompatLink,
-					),
-					fmt.Sprintf(
-						"[ ! -d %q ] && "+
-							"sudo ln -s %q %q",
-						d.GuestFolder,
-						d.GuestFolder,
-						d.GuestCompatLink,
-					),
-				}
-				commands = append(commands, compat_commands...)
-			}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Debugf("Failed to create session: %s", err)
+		return err
+	}
+	defer session.Close()
 
-			log.Debug(commands)
-			for _, command := range commands {
-				vmrun("-gu", B2DUser, "-gp", B2DPass, "runScriptInGuest", d.vmxPath(), "/bin/sh", command)
-			}
-		}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		// Closing the connection unblocks the session
+		client.Close()
+		return fmt.Errorf("command timed out after %s", timeout)
 	}
-	return nil
+}
+
+// installAuthorizedKey replaces the authorized_keys of the SSH user with the
+// key, streamed over stdin.
+func (d *Driver) installAuthorizedKey(key []byte) error {
+	sshDir := fmt.Sprintf("/home/%s/.ssh", d.SSHUser)
+	command := fmt.Sprintf(
+		"mkdir -p %s && chmod 700 %s && (umask 077 && cat > %s/authorized_keys) && chmod 600 %s/authorized_keys",
+		shQuote(sshDir), shQuote(sshDir), shQuote(sshDir), shQuote(sshDir),
+	)
+	return executeSSHCommandInput(command, bytes.NewReader(key), d)
 }
@@ -1,10 +1,18 @@
 package vmwareworkstation
 
 import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/ssh"
 	"github.com/stretchr/testify/assert"
+	cryptossh "golang.org/x/crypto/ssh"
 )
 
 func TestSetConfigFromFlags(t *testing.T) {
@@ -20,3 +28,211 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestRemoveLeftovers(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	driver := NewDriver("default", storePath).(*Driver)
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+	for _, file := range []string{"default.vmx", "default.vmdk", "default.nvram", "vmware.log", "userdata.tar"} {
+		assert.NoError(t, ioutil.WriteFile(driver.ResolveStorePath(file), nil, 0644))
+	}
+	assert.NoError(t, os.Mkdir(driver.ResolveStorePath("default.vmx.lck"), 0755))
+
+	err = driver.removeLeftovers()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "default.vmx.lck")
+	for _, file := range []string{"default.vmx", "default.vmdk", "default.nvram", "vmware.log", "userdata.tar"} {
+		_, err := os.Stat(driver.ResolveStorePath(file))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestGuestCredentials(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwareworkstation-ssh-user":     "core",
+			"vmwareworkstation-ssh-password": "secret",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(checkFlags))
+
+	user, password := driver.guestCredentials()
+	assert.Equal(t, "core", user)
+	assert.Equal(t, "secret", password)
+
+	driver.GuestUser, driver.GuestPassword = "admin", "admin-secret"
+	user, password = driver.guestCredentials()
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "admin-secret", password)
+}
+
+func newTestSSHDriver(t *testing.T, server *testSSHServer) (*Driver, func()) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+
+	driver := NewDriver("default", storePath).(*Driver)
+	driver.IPAddress = "127.0.0.1"
+	driver.SSHPort = server.port()
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+
+	return driver, func() {
+		os.RemoveAll(storePath)
+	}
+}
+
+func TestExecuteSSHCommandPinsHostKey(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+
+	assert.NoError(t, executeSSHCommand("true", driver))
+	pinned, err := ioutil.ReadFile(driver.ResolveStorePath(knownHostFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, string(cryptossh.MarshalAuthorizedKey(server.hostKey.PublicKey())), string(pinned))
+	assert.NoError(t, executeSSHCommand("true", driver))
+
+	other := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer other.Close()
+	driver.SSHPort = other.port()
+
+	err = executeSSHCommand("true", driver)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrHostKeyMismatch.Error())
+	assert.Empty(t, other.Commands())
+}
+
+func TestExecuteSSHCommandKeyAuth(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, "")
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+	driver.SSHPassword = ""
+
+	assert.Error(t, executeSSHCommand("true", driver))
+
+	assert.NoError(t, ssh.GenerateSSHKey(driver.GetSSHKeyPath()))
+	pubKey, err := ioutil.ReadFile(driver.publicSSHKeyPath())
+	assert.NoError(t, err)
+	server.authorize(t, pubKey)
+
+	assert.NoError(t, executeSSHCommand("true", driver))
+}
+
+func TestInstallAuthorizedKey(t *testing.T) {
+	server := newTestSSHServer(t, defaultSSHUser, defaultSSHPass)
+	defer server.Close()
+	driver, cleanup := newTestSSHDriver(t, server)
+	defer cleanup()
+	key := "ssh-rsa AAAAB3NzaC1yc2E 'quoted' $(key)\n"
+
+	err := driver.installAuthorizedKey([]byte(key))
+
+	assert.NoError(t, err)
+	assert.Len(t, server.Commands(), 1)
+	assert.NotContains(t, server.Commands()[0], "AAAAB3NzaC1yc2E")
+	assert.Contains(t, server.Commands()[0], "cat > '/home/docker/.ssh'/authorized_keys")
+	assert.Equal(t, []string{key}, server.Stdins())
+}
+
+func TestGenerateKeyBundleUserdataDir(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "vmwareworkstation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	userdataDir := filepath.Join(storePath, "userdata")
+	assert.NoError(t, os.MkdirAll(filepath.Join(userdataDir, ".docker"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(userdataDir, "bootsync.sh"), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(userdataDir, ".docker", "daemon.json"), []byte("{}"), 0600))
+
+	driver := NewDriver("default", storePath).(*Driver)
+	driver.UserdataDir = userdataDir
+	assert.NoError(t, os.MkdirAll(driver.ResolveStorePath("."), 0755))
+	assert.NoError(t, ioutil.WriteFile(driver.publicSSHKeyPath(), []byte("ssh-rsa AAAAB3NzaC1yc2E machine\n"), 0644))
+
+	assert.NoError(t, driver.generateKeyBundle())
+
+	f, err := os.Open(driver.ResolveStorePath("userdata.tar"))
+	assert.NoError(t, err)
+	defer f.Close()
+	var names []string
+	modes := map[string]int64{}
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		content, _ := ioutil.ReadAll(tr)
+		names = append(names, header.Name)
+		modes[header.Name] = header.Mode & 0777
+		contents[header.Name] = string(content)
+	}
+
+	assert.Equal(t, []string{
+		"boot2docker, this is vmware speaking",
+		".docker",
+		".docker/daemon.json",
+		"bootsync.sh",
+		".ssh",
+		".ssh/authorized_keys",
+		".ssh/authorized_keys2",
+	}, names)
+	assert.Equal(t, "{}", contents[".docker/daemon.json"])
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, int64(0600), modes[".docker/daemon.json"])
+	}
+	assert.Equal(t, int64(0111), modes["bootsync.sh"]&0111)
+}
+
+func TestUserdataBootCommand(t *testing.T) {
+	command := userdataBootCommand("/home/docker")
+
+	assert.Contains(t, command, "if [ -f /home/docker/daemon.json ]; then sudo mv /home/docker/daemon.json /var/lib/boot2docker/daemon.json; fi")
+	assert.Contains(t, command, "sudo cp -R /home/docker/certs/. /var/lib/boot2docker/certs/")
+	assert.Contains(t, command, "sudo tee -a /etc/ssl/certs/ca-certificates.crt")
+	assert.Contains(t, command, `grep -qF "`+userdataDaemonConfig+`" $f || echo "`+userdataDaemonConfig+`" >> $f`)
+	assert.Contains(t, command, "[ -f /var/lib/boot2docker/daemon.json ] || [ -d /var/lib/boot2docker/certs ]; then sudo /etc/init.d/docker restart")
+}
+
+func TestGUIMode(t *testing.T) {
+	defer os.Setenv(guiModeEnv, os.Getenv(guiModeEnv))
+	os.Setenv(guiModeEnv, "")
+	driver := NewDriver("default", "path").(*Driver)
+
+	assert.Equal(t, "nogui", driver.guiMode())
+
+	driver.GUI = true
+	assert.Equal(t, "gui", driver.guiMode())
+
+	os.Setenv(guiModeEnv, "NOGUI")
+	assert.Equal(t, "nogui", driver.guiMode())
+
+	os.Setenv(guiModeEnv, "window")
+	assert.Equal(t, "gui", driver.guiMode())
+}
+
+func TestBreakLocks(t *testing.T) {
+	defer os.Setenv(breakLocksEnv, os.Getenv(breakLocksEnv))
+	os.Setenv(breakLocksEnv, "")
+	driver := NewDriver("default", "path").(*Driver)
+
+	assert.False(t, driver.breakLocks())
+
+	os.Setenv(breakLocksEnv, "true")
+	assert.True(t, driver.breakLocks())
+
+	os.Setenv(breakLocksEnv, "")
+	driver.BreakLocks = true
+	assert.True(t, driver.breakLocks())
+}